@@ -2,18 +2,20 @@ package vessel
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"github.com/containerd/containerd"
-	"github.com/containerd/containerd/namespaces"
 	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/docker"
+	"github.com/deepfence/vessel/utils"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -25,32 +27,139 @@ func init() {
 
 // GetAddressAndDialer returns the address parsed from the given endpoint and a context dialer.
 func GetAddressAndDialer(endpoint string) (string, func(ctx context.Context, addr string) (net.Conn, error), error) {
-	protocol, addr, err := parseEndpointWithFallbackProtocol(endpoint, constants.UnixProtocol)
+	return GetAddressAndDialerWithOptions(endpoint, Options{})
+}
+
+// GetAddressAndDialerWithOptions returns the address parsed from the given endpoint and a context dialer
+// backed by the dialer configured in opts.
+func GetAddressAndDialerWithOptions(endpoint string, opts Options) (string, func(ctx context.Context, addr string) (net.Conn, error), error) {
+	ep, err := resolveEndpoint(endpoint, opts)
 	if err != nil {
 		return "", nil, err
 	}
-	if protocol != constants.UnixProtocol {
-		return "", nil, fmt.Errorf("only support unix socket endpoint")
+	return ep.addr, ep.dial, nil
+}
+
+// GetProtocolAddressAndDialer is GetAddressAndDialerWithOptions also returning the protocol of the endpoint,
+// like unix, tcp or vsock, so callers don't have to parse the endpoint again
+func GetProtocolAddressAndDialer(endpoint string, opts Options) (string, string, func(ctx context.Context, addr string) (net.Conn, error), error) {
+	ep, err := resolveEndpoint(endpoint, opts)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return ep.protocol, ep.addr, ep.dial, nil
+}
+
+// resolveEndpoint parses the endpoint and sets up the dialer and TLS settings used to connect to it
+func resolveEndpoint(endpoint string, opts Options) (*runtimeEndpoint, error) {
+	endpoint, timeout, err := splitEndpointQuery(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = opts.timeout()
+	}
+	protocol, addr, err := parseEndpointWithFallbackProtocol(endpoint, constants.UnixProtocol)
+	if err != nil {
+		return nil, err
+	}
+	ep := &runtimeEndpoint{
+		url:       endpoint,
+		protocol:  protocol,
+		addr:      addr,
+		dial:      dialWith(opts.netDialer(), protocol),
+		timeout:   timeout,
+		namespace: opts.Namespace,
 	}
+	switch protocol {
+	case constants.UnixProtocol:
+		ep.addr = resolveSocketPath(addr)
+	case constants.TCPProtocol:
+		ep.tlsConfig = opts.tlsConfig()
+	case constants.VsockProtocol:
+		ep.dial = dialVsock
+	case constants.HTTPProtocol:
+		ep.dial = dialWith(opts.netDialer(), constants.TCPProtocol)
+	case constants.HTTPSProtocol:
+		ep.dial = dialWith(opts.netDialer(), constants.TCPProtocol)
+		ep.tlsConfig = opts.tlsConfig()
+		if ep.tlsConfig == nil {
+			ep.tlsConfig = &tls.Config{}
+		}
+	case constants.SSHProtocol:
+		ep.dial, err = sshDialer(endpoint, opts.SSHKeyPath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("only support unix socket, tcp, http(s), ssh and vsock endpoints")
+	}
+	return ep, nil
+}
 
-	return addr, dial, nil
+// splitEndpointQuery strips the query and fragment from the endpoint so they can't end up in a socket path,
+// returning the timeout set with the timeout query parameter or else zero
+func splitEndpointQuery(endpoint string) (string, time.Duration, error) {
+	i := strings.IndexAny(endpoint, "?#")
+	if i < 0 {
+		return endpoint, 0, nil
+	}
+	// the fragment is ignored
+	query := endpoint[i:]
+	if j := strings.Index(query, "#"); j >= 0 {
+		query = query[:j]
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return "", 0, &endpointError{msg: fmt.Sprintf("invalid query in endpoint %q", endpoint), err: err}
+	}
+	var timeout time.Duration
+	for key, value := range values {
+		if key != "timeout" {
+			logrus.Warnf("ignoring unsupported query parameter %q of endpoint %q", key, endpoint)
+			continue
+		}
+		timeout, err = time.ParseDuration(value[0])
+		if err != nil || timeout <= 0 {
+			return "", 0, fmt.Errorf("invalid timeout %q in endpoint %q", value[0], endpoint)
+		}
+	}
+	return endpoint[:i], timeout, nil
 }
 
-func dial(ctx context.Context, addr string) (net.Conn, error) {
-	return (&net.Dialer{}).DialContext(ctx, constants.UnixProtocol, addr)
+func dialWith(dialer *net.Dialer, protocol string) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, protocol, addr)
+	}
 }
 
+// parseEndpointWithFallbackProtocol parses the endpoint, an endpoint without a scheme is taken to use the fallback
+// protocol, or tcp when it is a host:port like localhost:2375 rather than a socket path
 func parseEndpointWithFallbackProtocol(endpoint string, fallbackProtocol string) (protocol string, addr string, err error) {
-	if protocol, addr, err = parseEndpoint(endpoint); err != nil && protocol == "" {
-		fallbackEndpoint := fallbackProtocol + "://" + endpoint
-		protocol, addr, err = parseEndpoint(fallbackEndpoint)
-		if err == nil {
-			logrus.Warningf("Using %q as endpoint is deprecated, please consider using full url format %q.", endpoint, fallbackEndpoint)
-		}
+	if !strings.Contains(endpoint, "://") && isHostPort(endpoint) {
+		// url.Parse takes the host of localhost:2375 for a scheme
+		fallbackProtocol = constants.TCPProtocol
+	} else if protocol, addr, err = parseEndpoint(endpoint); err == nil || protocol != "" {
+		return
+	}
+	fallbackEndpoint := fallbackProtocol + "://" + endpoint
+	protocol, addr, err = parseEndpoint(fallbackEndpoint)
+	if err == nil {
+		logrus.Warningf("Using %q as endpoint is deprecated, please consider using full url format %q.", endpoint, fallbackEndpoint)
 	}
 	return
 }
 
+// isHostPort reports whether the endpoint is a host:port, like localhost:2375 or [::1]:2375
+func isHostPort(endpoint string) bool {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" || strings.Contains(host, "/") {
+		return false
+	}
+	portNumber, err := strconv.Atoi(port)
+	return err == nil && portNumber > 0 && portNumber <= 65535
+}
+
 func parseEndpoint(endpoint string) (string, string, error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
@@ -64,126 +173,267 @@ func parseEndpoint(endpoint string) (string, string, error) {
 	case "unix":
 		return "unix", u.Path, nil
 
+	case "vsock":
+		return "vsock", u.Host, nil
+
+	case "http", "https":
+		// the docker client dials the address as is, it has to carry the scheme's default port
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+		}
+		return u.Scheme, u.Host, nil
+
+	case "ssh":
+		return "ssh", u.Host, nil
+
 	case "":
-		return "", "", fmt.Errorf("using %q as endpoint is deprecated, please consider using full url format", endpoint)
+		return "", "", &endpointError{
+			msg: fmt.Sprintf("using %q as endpoint is deprecated, please consider using full url format", endpoint),
+			err: ErrEmptyScheme,
+		}
 
 	default:
-		return u.Scheme, "", fmt.Errorf("protocol %q not supported", u.Scheme)
+		return u.Scheme, "", &UnsupportedProtocolError{Scheme: u.Scheme}
 	}
 }
 
-// getContainerRuntime returns the underlying container runtime and it's socket path
-func getContainerRuntime(endPoints map[string]string) (string, string, error) {
-	if endPoints == nil || len(endPoints) == 0 {
-		return "", "", fmt.Errorf("endpoint is not set")
+// getContainerRuntime returns the first runtime detected on the endpoints, nil when none is detected
+func getContainerRuntime(ctx context.Context, endPoints []Endpoint, opts Options) (*DetectedRuntime, error) {
+	if len(endPoints) == 0 {
+		return nil, fmt.Errorf("endpoint is not set")
 	}
-	var detectedRuntime string
-	var sockPath string
-	for endPoint, runtime := range endPoints {
-		logrus.Infof("trying to connect to endpoint '%s' with timeout '%s'", endPoint, constants.Timeout)
-		addr, dialer, err := GetAddressAndDialer(endPoint)
+	log := opts.logger()
+	var permErr error
+	for _, candidate := range endPoints {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		endPoint, runtime := candidate.URL, candidate.Runtime
+		if !containerdSupported && runtime != constants.DOCKER {
+			log.Debugf("skipping %s endpoint '%s', containerd support is not compiled in", runtime, endPoint)
+			continue
+		}
+		ep, err := candidate.resolve(opts)
 		if err != nil {
-			logrus.Warn(err)
+			log.Warn(err)
 			continue
 		}
-
-		if runtime == constants.DOCKER {
-			_, err = net.DialTimeout(constants.UnixProtocol, addr, constants.Timeout)
-			if err != nil {
-				errMsg := errors.Wrapf(err, "could not connect to endpoint '%s'", endPoint)
-				logrus.Warn(errMsg)
-				continue
-			}
-			running, err := isDockerRunning(endPoint)
-			if err != nil {
-				logrus.Warn(err)
-				continue
-			}
-			if !running {
-				logrus.Warn(errors.New(fmt.Sprintf("no running containers found with endpoint %s", endPoint)))
-				continue
-			}
-			logrus.Infof("connected successfully using endpoint: %s", endPoint)
-			detectedRuntime = runtime
-			sockPath = endPoint
-			break
-		} else {
-			_, err = grpc.Dial(addr, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(constants.Timeout), grpc.WithContextDialer(dialer))
-			if err != nil {
-				errMsg := errors.Wrapf(err, "could not connect to endpoint '%s'", endPoint)
-				logrus.Warn(errMsg)
-				continue
-			}
-			running, err := isContainerdRunning(endPoint)
-			if err != nil {
-				logrus.Warn(err)
-				continue
-			}
-			if !running {
-				logrus.Warn(errors.New(fmt.Sprintf("no running containers found with endpoint %s", endPoint)))
-				continue
+		log.Infof("trying to connect to endpoint '%s' with timeout '%s'", endPoint, ep.timeout)
+		start := time.Now()
+		detected, err := probeEndpoint(ctx, ep, runtime, opts)
+		opts.recordProbe(runtime, detected, time.Since(start), err)
+		if err != nil {
+			if errors.Is(err, ErrPermissionDenied) {
+				permErr = err
 			}
-			logrus.Infof("connected successfully using endpoint: %s", endPoint)
-			detectedRuntime = runtime
-			sockPath = endPoint
-			break
+			logProbeError(log, err)
+			opts.reportProbe(endPoint, runtime, EndpointUnreachable, err)
+			continue
 		}
+		if !detected {
+			log.Infof("%s is reachable at endpoint %s but has no containers", runtime, endPoint)
+			opts.reportProbe(endPoint, runtime, EndpointEmpty, nil)
+			continue
+		}
+		opts.reportProbe(endPoint, runtime, EndpointDetected, nil)
+		log.Infof("connected successfully using endpoint: %s", endPoint)
+		found := newDetectedRuntime(runtime, ep.url)
+		if runtime == constants.CONTAINERD {
+			found.Namespace = ep.containerdNamespace()
+		}
+		return &found, nil
+	}
+	if permErr != nil {
+		return nil, permErr
+	}
+	return nil, nil
+}
+
+// logProbeError logs an endpoint probe failure, the runtime being absent from the node is
+// expected during detection so it's only logged at debug level
+func logProbeError(log logrus.FieldLogger, err error) {
+	if isEndpointAbsent(err) {
+		log.Debug(err)
+		return
 	}
-	return detectedRuntime, sockPath, nil
+	log.Warn(err)
 }
 
-// AutoDetectRuntime auto detects the underlying container runtime like docker, containerd
+// AutoDetectRuntime auto detects the underlying container runtime like docker, containerd.
+// The system sockets, like /var/run/docker.sock and /run/containerd/containerd.sock, are probed
+// before the sockets under the user's home and runtime directories, like Colima's or rootless
+// containerd's, so the first runtime found is the system's when both are running.
 func AutoDetectRuntime() (string, string, error) {
-	runtime, sockPath, err := getContainerRuntime(constants.SupportedRuntimes)
+	detected, err := NewDetector().Detect(context.Background())
 	if err != nil {
 		return "", "", err
 	}
-	if runtime == "" {
-		return "", "", errors.New("could not detect container runtime")
+	return detected.Runtime, detected.SocketPath, nil
+}
+
+// AutoDetectRuntimeContext is AutoDetectRuntime abandoning detection once ctx is done, including an
+// endpoint dial or probe in flight, for callers like liveness probes which can't wait out the timeouts
+func AutoDetectRuntimeContext(ctx context.Context) (string, string, error) {
+	detected, err := NewDetector().Detect(ctx)
+	if err != nil {
+		return "", "", err
 	}
-	logrus.Infof("container runtime detected: %s\n", runtime)
-	return runtime, sockPath, nil
+	return detected.Runtime, detected.SocketPath, nil
 }
 
-func isDockerRunning(host string) (bool, error) {
-	dockerCli, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation(), client.WithHost(host), client.WithTimeout(constants.Timeout))
+// AutoDetectRuntimeWithOptions auto detects the underlying container runtime using the given options
+func AutoDetectRuntimeWithOptions(opts Options) (string, string, error) {
+	detected, err := detectRuntime(context.Background(), opts)
 	if err != nil {
-		return false, errors.Wrapf(err, " :error creating docker client")
+		return "", "", err
 	}
-	defer dockerCli.Close()
-	containers, err := dockerCli.ContainerList(context.Background(), types.ContainerListOptions{
-		Quiet: true, All: true, Size: false,
-	})
+	return detected.Runtime, detected.SocketPath, nil
+}
+
+// detectRuntime probes the candidate endpoints of opts group by group until a runtime is detected
+func detectRuntime(ctx context.Context, opts Options) (*DetectedRuntime, error) {
+	if _, err := utils.ParseLabelSelector(opts.LabelSelector); err != nil {
+		return nil, err
+	}
+	var permErr error
+	for _, endPoints := range candidateEndpoints(opts) {
+		detected, err := getContainerRuntime(ctx, endPoints, opts)
+		if errors.Is(err, ErrPermissionDenied) {
+			// a runtime may still be detected on a later endpoint
+			permErr = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if detected != nil {
+			opts.logger().Infof("container runtime detected: %s\n", detected.Runtime)
+			return detected, nil
+		}
+	}
+	if permErr != nil {
+		return nil, permErr
+	}
+	return nil, errors.New("could not detect container runtime")
+}
+
+// AutoDetectRuntimeWithHint auto detects the underlying container runtime, probing the endpoints of the
+// preferred runtime first and the other runtimes only if the preferred one isn't detected
+func AutoDetectRuntimeWithHint(preferred RuntimeType) (string, string, error) {
+	return AutoDetectRuntimeWithOptions(Options{PreferredRuntime: preferred})
+}
+
+// AutoDetectRuntimeType auto detects the underlying container runtime, returning its type and socket path
+func AutoDetectRuntimeType() (RuntimeType, string, error) {
+	runtime, sockPath, err := AutoDetectRuntime()
 	if err != nil {
-		return false, errors.Wrapf(err, " :error creating docker client")
+		return "", "", err
 	}
+	runtimeType, err := ParseRuntimeType(runtime)
+	if err != nil {
+		return "", "", err
+	}
+	return runtimeType, sockPath, nil
+}
 
-	if len(containers) > 0 {
+// probeEndpoint reports whether the runtime at the endpoint counts as detected for its detection mode in opts,
+// the probe is abandoned once ctx is done
+func probeEndpoint(ctx context.Context, ep *runtimeEndpoint, runtime string, opts Options) (bool, error) {
+	if err := ep.checkSocket(); err != nil {
+		return false, permissionError(ep.addr, err)
+	}
+	var detected bool
+	var err error
+	if runtime == constants.DOCKER {
+		detected, err = probeDocker(ctx, ep, opts.detectBy(runtime), opts)
+	} else {
+		detected, err = probeContainerd(ctx, ep, opts.detectBy(runtime), opts)
+	}
+	if err != nil {
+		return false, permissionError(ep.addr, err)
+	}
+	return detected, nil
+}
+
+// probeDocker reports whether the docker daemon at the endpoint counts as detected for the detection mode
+func probeDocker(ctx context.Context, ep *runtimeEndpoint, detectBy DetectBy, opts Options) (bool, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, ep.timeout)
+	conn, err := ep.dial(dialCtx, ep.addr)
+	cancel()
+	if err != nil {
+		return false, errors.Wrapf(err, "could not connect to endpoint '%s'", ep.url)
+	}
+	conn.Close()
+
+	switch detectBy {
+	case SocketReachable:
 		return true, nil
+	case DaemonResponds:
+		return isDockerResponding(ctx, ep)
+	default:
+		selector, err := utils.ParseLabelSelector(opts.LabelSelector)
+		if err != nil {
+			return false, err
+		}
+		return isDockerRunning(ctx, ep, selector)
 	}
+}
 
-	return false, nil
+// newDockerClient creates a docker client for the calls of one function, with keep-alives disabled so
+// its connections don't outlive it
+func newDockerClient(ep *runtimeEndpoint) (*client.Client, error) {
+	return dockerClient(ep, docker.DisableKeepAlives())
 }
 
-func isContainerdRunning(host string) (bool, error) {
-	clientd, err := containerd.New(strings.Replace(host, "unix://", "", 1))
-	if err != nil {
-		return false, errors.Wrapf(err, " :error creating containerd client")
+// dockerClient creates a docker client for the endpoint, applying the extra options last
+func dockerClient(ep *runtimeEndpoint, extraOpts ...client.Opt) (*client.Client, error) {
+	host := ep.url
+	switch ep.protocol {
+	case constants.UnixProtocol:
+		// the endpoint may be a bare socket path
+		host = constants.UnixProtocol + "://" + ep.addr
+	case constants.SSHProtocol:
+		// the docker client only speaks http, the tunnel is provided by the dialer
+		host = constants.HTTPProtocol + "://" + ep.addr
 	}
-	defer clientd.Close()
+	opts := []client.Opt{client.WithAPIVersionNegotiation(), client.WithHost(host), client.WithTimeout(ep.timeout),
+		client.WithDialContext(ep.dialDocker)}
+	return client.NewClientWithOpts(append(opts, extraOpts...)...)
+}
 
-	// create a context for k8s with containerd namespace
-	// TODO: using k8s ns, to support containerd standalone
-	// make this configurable or autodetect
-	k8s := namespaces.WithNamespace(context.Background(), constants.CONTAINERD_K8S_NS)
+func isDockerResponding(ctx context.Context, ep *runtimeEndpoint) (bool, error) {
+	dockerCli, err := newDockerClient(ep)
+	if err != nil {
+		return false, errors.Wrapf(err, " :error creating docker client")
+	}
+	defer dockerCli.Close()
+	if _, err = dockerCli.Ping(ctx); err != nil {
+		return false, errors.Wrapf(err, " :error pinging docker daemon")
+	}
+	return true, nil
+}
 
-	containers, err := clientd.Containers(k8s)
+// isDockerRunning reports whether docker has containers, only counting the ones matching the selector
+func isDockerRunning(ctx context.Context, ep *runtimeEndpoint, selector utils.LabelSelector) (bool, error) {
+	dockerCli, err := newDockerClient(ep)
 	if err != nil {
-		return false, errors.Wrapf(err, " :error creating containerd client")
+		return false, errors.Wrapf(err, " :error creating docker client")
+	}
+	defer dockerCli.Close()
+	containers, err := dockerCli.ContainerList(ctx, types.ContainerListOptions{
+		Quiet: true, All: true, Size: false, Filters: selector.DockerFilters(),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, " :error creating docker client")
 	}
 
 	if len(containers) > 0 {
 		return true, nil
 	}
+
 	return false, nil
 }
@@ -0,0 +1,31 @@
+package vessel
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		protocol string
+		addr     string
+	}{
+		{"http://docker.example.com", "http", "docker.example.com:80"},
+		{"http://docker.example.com:2375", "http", "docker.example.com:2375"},
+		{"https://docker.example.com", "https", "docker.example.com:443"},
+		{"https://docker.example.com:2376", "https", "docker.example.com:2376"},
+		{"https://[::1]", "https", "[::1]:443"},
+		{"https://[::1]:2376", "https", "[::1]:2376"},
+		{"tcp://localhost:2375", "tcp", "localhost:2375"},
+		{"unix:///var/run/docker.sock", "unix", "/var/run/docker.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			protocol, addr, err := parseEndpoint(tt.endpoint)
+			if err != nil {
+				t.Fatalf("parseEndpoint(%q) error = %v", tt.endpoint, err)
+			}
+			if protocol != tt.protocol || addr != tt.addr {
+				t.Errorf("parseEndpoint(%q) = %q, %q, want %q, %q", tt.endpoint, protocol, addr, tt.protocol, tt.addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package vessel
+
+import (
+	"bufio"
+	"github.com/deepfence/vessel/models"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// unsafeDirChars matches the characters of an image reference that aren't kept in its extraction directory name,
+// like the / and : of registry.io/library/nginx:1.21
+var unsafeDirChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// BatchExtractOptions configures ExtractImages
+type BatchExtractOptions struct {
+	// ExtractOptions are applied to the extraction of every image, a Progress func is called concurrently
+	// when Concurrency is above 1
+	models.ExtractOptions
+	// Concurrency bounds how many images are extracted at once, one at a time when 0
+	Concurrency int
+}
+
+// ExtractResult is the outcome of extracting one of the images of ExtractImages
+type ExtractResult struct {
+	// Ref is the image reference as passed to ExtractImages
+	Ref string `json:"ref"`
+	// Path is the directory the image was extracted into
+	Path string `json:"path"`
+	// Err is the error extracting the image, nil when it was extracted
+	Err error `json:"-"`
+}
+
+// ExtractImages extracts each of the images into its own subdirectory of outDir, named after the reference with
+// the characters unfit for a file name replaced by _, like registry.io_library_nginx_1.21. A failed image doesn't
+// stop the others, its directory is removed and the error is kept in its result. The results are in the order of
+// refs, the returned error is only set when outDir can't be created.
+func ExtractImages(runtime Runtime, refs []string, outDir string, opts BatchExtractOptions) ([]ExtractResult, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, " :error creating output directory")
+	}
+	results := make([]ExtractResult, len(refs))
+	used := make(map[string]bool, len(refs))
+	for i, ref := range refs {
+		results[i] = ExtractResult{Ref: ref, Path: filepath.Join(outDir, uniqueDirName(ref, used))}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(refs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].Err = extractInto(runtime, results[i].Ref, results[i].Path, opts.ExtractOptions)
+			}
+		}()
+	}
+	for i := range refs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, nil
+}
+
+// extractInto extracts the image into a new directory at path, removing the directory when the extraction fails
+func extractInto(runtime Runtime, ref, path string, opts models.ExtractOptions) error {
+	imageID, err := runtime.GetImageID(ref)
+	if err != nil {
+		return err
+	}
+	if err = os.Mkdir(path, 0755); err != nil {
+		return errors.Wrapf(err, " :error creating extraction directory")
+	}
+	err = runtime.ExtractImageWithOptions(strings.TrimSpace(string(imageID)), ref, path, opts)
+	if err != nil {
+		os.RemoveAll(path)
+		return err
+	}
+	return nil
+}
+
+// uniqueDirName returns the extraction directory name of ref, suffixed with -2, -3... when a previous
+// reference, like a duplicate, already got the name
+func uniqueDirName(ref string, used map[string]bool) string {
+	base := strings.Trim(unsafeDirChars.ReplaceAllString(ref, "_"), "_.")
+	if base == "" {
+		base = "image"
+	}
+	name := base
+	for n := 2; used[name]; n++ {
+		name = base + "-" + strconv.Itoa(n)
+	}
+	used[name] = true
+	return name
+}
+
+// ReadImageRefs reads image references one per line, like from a file of images to pass to ExtractImages.
+// Blank lines and lines starting with # are skipped and surrounding whitespace is trimmed.
+func ReadImageRefs(r io.Reader) ([]string, error) {
+	var refs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, " :error reading image references")
+	}
+	return refs, nil
+}
+
+// ReadImageRefsFile is ReadImageRefs for the file at path
+func ReadImageRefsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadImageRefs(f)
+}
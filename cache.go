@@ -0,0 +1,118 @@
+package vessel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DetectionCache caches detection results for a while, keyed on the detection configuration so detections
+// probing different endpoints or namespaces, like the tenants of a multi-tenant agent, never share a result.
+// It is safe for concurrent use.
+type DetectionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedDetection
+}
+
+type cachedDetection struct {
+	detected DetectedRuntime
+	expires  time.Time
+}
+
+// NewDetectionCache returns a cache keeping detection results for ttl
+func NewDetectionCache(ttl time.Duration) *DetectionCache {
+	return &DetectionCache{ttl: ttl, entries: make(map[string]cachedDetection)}
+}
+
+// Detect returns the cached result of detecting with opts, detecting and caching it when there is none or it
+// has expired. Failed detections aren't cached.
+func (c *DetectionCache) Detect(ctx context.Context, opts Options) (*DetectedRuntime, error) {
+	key := CacheKey(opts)
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		detected := entry.detected
+		return &detected, nil
+	}
+
+	detected, err := detectRuntime(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = cachedDetection{detected: *detected, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return detected, nil
+}
+
+// Invalidate drops the cached result of detecting with opts, like after its runtime restarted
+func (c *DetectionCache) Invalidate(opts Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, CacheKey(opts))
+}
+
+// Flush drops every cached result
+func (c *DetectionCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cachedDetection)
+}
+
+// cacheKeyEndpoint is the part of an endpoint which changes the detection result
+type cacheKeyEndpoint struct {
+	URL       string `json:"url"`
+	Runtime   string `json:"runtime"`
+	Namespace string `json:"namespace"`
+}
+
+// CacheKey returns the key detection results for opts are cached under, a hash of every option which changes
+// the result: the endpoints in probe order with their runtimes and namespaces, the endpoint groups probed,
+// the namespace, the detection modes, the label selector and the connection security. Timeouts, the dialer,
+// the logger and the probe callback don't change which runtime is detected and are left out.
+func CacheKey(opts Options) string {
+	key := struct {
+		Endpoints           []cacheKeyEndpoint       `json:"endpoints"`
+		UseDockerContext    bool                     `json:"useDockerContext"`
+		UseDockerContainerd bool                     `json:"useDockerContainerd"`
+		PreferNestedDocker  bool                     `json:"preferNestedDocker"`
+		PreferredRuntime    RuntimeType              `json:"preferredRuntime"`
+		DetectBy            DetectBy                 `json:"detectBy"`
+		DetectByRuntime     map[RuntimeType]DetectBy `json:"detectByRuntime"`
+		Namespace           string                   `json:"namespace"`
+		DetectNamespace     bool                     `json:"detectNamespace"`
+		LabelSelector       string                   `json:"labelSelector"`
+		TLS                 bool                     `json:"tls"`
+		InsecureSkipVerify  bool                     `json:"insecureSkipVerify"`
+		SSHKeyPath          string                   `json:"sshKeyPath"`
+	}{
+		UseDockerContext:    opts.UseDockerContext,
+		UseDockerContainerd: opts.UseDockerContainerd,
+		PreferNestedDocker:  opts.PreferNestedDocker,
+		PreferredRuntime:    opts.PreferredRuntime,
+		DetectBy:            opts.DetectBy,
+		DetectByRuntime:     opts.DetectByRuntime,
+		Namespace:           opts.Namespace,
+		DetectNamespace:     opts.DetectNamespace,
+		LabelSelector:       opts.LabelSelector,
+		TLS:                 opts.TLSConfig != nil,
+		InsecureSkipVerify:  opts.InsecureSkipVerify,
+		SSHKeyPath:          opts.SSHKeyPath,
+	}
+	for _, endPoint := range opts.Endpoints {
+		key.Endpoints = append(key.Endpoints, cacheKeyEndpoint{
+			URL:       endPoint.URL,
+			Runtime:   endPoint.Runtime,
+			Namespace: endPoint.Namespace,
+		})
+	}
+	// maps are marshalled with sorted keys, so equal options always hash the same
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
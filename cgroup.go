@@ -0,0 +1,113 @@
+package vessel
+
+import (
+	"errors"
+	"github.com/deepfence/vessel/constants"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup hierarchies are mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupScanDepth bounds how deep the cgroup hierarchy is scanned for container cgroups,
+// kubepods/burstable/pod<uid>/<container> is the deepest layout in use
+const cgroupScanDepth = 5
+
+// scopePrefixes map the prefixes of the cgroups the systemd cgroup driver creates for containers,
+// like docker-<id>.scope, to their runtime
+var scopePrefixes = map[string]string{
+	"cri-containerd-": constants.CONTAINERD,
+	"crio-":           constants.CRIO,
+	"docker-":         constants.DOCKER,
+}
+
+// cgroupfsParents map the parent cgroups the cgroupfs cgroup driver creates containers under,
+// like docker/<id>, to their runtime
+var cgroupfsParents = map[string]string{
+	"docker": constants.DOCKER,
+}
+
+// DetectRuntimeFromCgroup infers the container runtime from the cgroup hierarchy, for nodes where socket based
+// detection fails because the runtime socket is at a nonstandard path. The cgroups of the current process and of
+// pid 1 are checked first, which tells the runtime vessel itself runs under, and then the cgroups of the node.
+// Kubelet cgroups without a runtime specific name are taken to be containerd's. Only the runtime is returned,
+// the socket still has to be found to talk to it.
+func DetectRuntimeFromCgroup() (string, error) {
+	kubepods := false
+	for _, procFile := range []string{"/proc/self/cgroup", "/proc/1/cgroup"} {
+		data, err := ioutil.ReadFile(procFile)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			// hierarchy-id:controllers:path
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			for _, name := range strings.Split(fields[2], "/") {
+				if runtime := cgroupRuntime(name); runtime != "" {
+					return runtime, nil
+				}
+				kubepods = kubepods || strings.HasPrefix(name, "kubepods")
+			}
+		}
+	}
+
+	var detected string
+	for _, root := range cgroupHierarchies() {
+		rootDepth := strings.Count(root, string(os.PathSeparator))
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if runtime := cgroupRuntime(info.Name()); runtime != "" && path != root {
+				detected = runtime
+				return errStopWalk
+			}
+			kubepods = kubepods || strings.HasPrefix(info.Name(), "kubepods")
+			if strings.Count(path, string(os.PathSeparator))-rootDepth >= cgroupScanDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if detected != "" {
+			return detected, nil
+		}
+	}
+	if kubepods {
+		return constants.CONTAINERD, nil
+	}
+	return "", errors.New("could not detect container runtime from cgroups")
+}
+
+// errStopWalk ends a filepath.Walk early
+var errStopWalk = errors.New("stop walk")
+
+// cgroupRuntime returns the runtime whose container cgroups are named like name, if any
+func cgroupRuntime(name string) string {
+	if runtime, ok := cgroupfsParents[name]; ok {
+		return runtime
+	}
+	if !strings.HasSuffix(name, ".scope") {
+		return ""
+	}
+	for prefix, runtime := range scopePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return runtime
+		}
+	}
+	return ""
+}
+
+// cgroupHierarchies returns the cgroup hierarchies to scan: the unified cgroup v2 hierarchy, or on
+// cgroup v1 the pids and memory hierarchies every container is placed in
+func cgroupHierarchies() []string {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return []string{cgroupRoot}
+	}
+	return []string{filepath.Join(cgroupRoot, "pids"), filepath.Join(cgroupRoot, "memory")}
+}
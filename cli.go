@@ -0,0 +1,63 @@
+package vessel
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+)
+
+// Exit codes returned by RunDetectCLI
+const (
+	ExitDetected    = 0
+	ExitNotDetected = 1
+	ExitUsage       = 2
+)
+
+// RunDetectCLI runs runtime detection as a command line tool, printing the detected runtime and socket
+// to stdout in a human readable form or as JSON with -json. It returns the process exit code:
+// ExitDetected, ExitNotDetected or ExitUsage for invalid arguments.
+func RunDetectCLI(args []string) int {
+	flags := flag.NewFlagSet("detect", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the detection result as JSON")
+	useDockerContext := flags.Bool("docker-context", false, "probe the current docker context endpoint first")
+	preferNested := flags.Bool("prefer-nested", false, "prefer a nested docker-in-docker daemon over a mounted host socket")
+	quiet := flags.Bool("quiet", false, "don't log detection progress")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", flags.Args())
+		flags.Usage()
+		return ExitUsage
+	}
+	if *quiet {
+		logrus.SetOutput(ioutil.Discard)
+	}
+
+	detected, out, err := detectAndMarshal(context.Background(), NewDetectorWithOptions(Options{
+		UseDockerContext:   *useDockerContext,
+		PreferNestedDocker: *preferNested,
+	}))
+	if err != nil {
+		if *jsonOutput {
+			out, _ := json.Marshal(DetectedRuntime{})
+			fmt.Println(string(out))
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return ExitNotDetected
+	}
+
+	if *jsonOutput {
+		fmt.Println(string(out))
+		return ExitDetected
+	}
+	fmt.Printf("runtime: %s\nsocket: %s\n", detected.Runtime, detected.SocketPath)
+	if detected.Namespace != "" {
+		fmt.Printf("namespace: %s\n", detected.Namespace)
+	}
+	return ExitDetected
+}
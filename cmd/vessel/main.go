@@ -12,7 +12,8 @@ var activeRuntime string
 var sockPath string
 var containerRuntime string
 
-func init() {
+// detect auto-detects the underlying container runtime
+func detect() {
 	var err error
 	// Auto-detect underlying container runtime
 	containerRuntime, sockPath, err = vessel.AutoDetectRuntime()
@@ -39,6 +40,11 @@ func setDotEnvVariable(envars map[string]string) error {
 }
 
 func main() {
+	// `vessel detect` prints the detection result instead of writing .env
+	if len(os.Args) > 1 && os.Args[1] == "detect" {
+		os.Exit(vessel.RunDetectCLI(os.Args[2:]))
+	}
+	detect()
 	if activeRuntime != "" {
 		envVars := map[string]string{
 			"CONTAINER_RUNTIME": containerRuntime,
@@ -0,0 +1,45 @@
+package vessel
+
+import (
+	"github.com/docker/docker/client"
+	"net/http"
+	"time"
+)
+
+// dockerIdleConnTimeout closes the keep-alive connections of the clients returned by ConnectDocker after they
+// have been idle for a while, the docker client's transport otherwise keeps them open indefinitely
+const dockerIdleConnTimeout = 90 * time.Second
+
+// ConnectDocker returns a docker client for the daemon at host, which may be a bare socket path. It is set up the way vessel connects to docker:
+// api version negotiation, the detection timeout and support for every endpoint protocol vessel supports
+func ConnectDocker(host string) (*client.Client, error) {
+	return ConnectDockerWithOptions(host, Options{})
+}
+
+// ConnectDockerWithOptions is ConnectDocker connecting with the dialer and TLS settings of opts.
+// The client keeps its connections alive for reuse, closing them once idle for 90s.
+func ConnectDockerWithOptions(host string, opts Options) (*client.Client, error) {
+	ep, err := resolveEndpoint(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dockerClient(ep, withIdleConnTimeout(dockerIdleConnTimeout))
+}
+
+// CloseIdleConnections closes the idle keep-alive connections of a docker client, like one from ConnectDocker
+// reused across scans, without closing connections in use. The client remains usable.
+func CloseIdleConnections(cli *client.Client) {
+	if transport, ok := cli.HTTPClient().Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// withIdleConnTimeout closes the connections of the docker client that have been idle for timeout
+func withIdleConnTimeout(timeout time.Duration) client.Opt {
+	return func(c *client.Client) error {
+		if transport, ok := c.HTTPClient().Transport.(*http.Transport); ok {
+			transport.IdleConnTimeout = timeout
+		}
+		return nil
+	}
+}
@@ -4,13 +4,70 @@ import "time"
 
 const (
 	UnixProtocol      = "unix"
+	TCPProtocol       = "tcp"
+	VsockProtocol     = "vsock"
+	HTTPProtocol      = "http"
+	HTTPSProtocol     = "https"
+	SSHProtocol       = "ssh"
 	Timeout           = 10 * time.Second
 	CONTAINERD_K8S_NS = "k8s.io"
-	CONTAINERD        = "containerd"
-	DOCKER            = "docker"
+	// CONTAINERD_MOBY_NS is the containerd namespace of docker's containers, and of its images
+	// when docker uses the containerd image store
+	CONTAINERD_MOBY_NS = "moby"
+	// CONTAINERD_DEFAULT_NS is the containerd namespace of ctr and nerdctl, rootless nerdctl included
+	CONTAINERD_DEFAULT_NS = "default"
+	CONTAINERD            = "containerd"
+	DOCKER                = "docker"
+	CRIO                  = "cri-o"
+	// TARBALL is the runtime name of a saved image tarball read without a daemon
+	TARBALL = "tarball"
+
+	// KubernetesPodNameLabel is set by the kubelet on the containers of a pod
+	KubernetesPodNameLabel = "io.kubernetes.pod.name"
 )
 
 var SupportedRuntimes = map[string]string{
-	"unix:///var/run/docker.sock": DOCKER,
+	"unix:///var/run/docker.sock":            DOCKER,
+	"unix:///var/run/docker-host.sock":       DOCKER,
 	"unix:///run/containerd/containerd.sock": CONTAINERD,
 }
+
+// GlobRuntimes are socket path patterns of runtimes embedded in kubernetes distributions, like the containerd of
+// k3s and rke2 at /run/k3s/containerd/containerd.sock. Every matching socket is probed.
+var GlobRuntimes = map[string]string{
+	"/run/*/containerd/containerd.sock": CONTAINERD,
+}
+
+// HomeRuntimes are well-known socket paths relative to the running user's home directory,
+// used by developer setups like Colima and Rancher Desktop on macOS
+var HomeRuntimes = map[string]string{
+	".colima/default/docker.sock": DOCKER,
+	".colima/docker.sock":         DOCKER,
+	".rd/docker.sock":             DOCKER,
+}
+
+// UserRuntimeDirRuntimes are socket paths relative to the running user's runtime directory
+// ($XDG_RUNTIME_DIR or /run/user/$UID), used by rootless runtimes. Their containerd endpoints are
+// probed in the default namespace, where rootless nerdctl keeps its containers.
+var UserRuntimeDirRuntimes = map[string]string{
+	"containerd/containerd.sock": CONTAINERD,
+}
+
+// NestedDockerRuntimes are endpoints of a docker daemon nested in the container vessel runs in,
+// like the docker:dind service of CI pipelines. They are only probed when running in a container.
+var NestedDockerRuntimes = map[string]string{
+	"tcp://docker:2375":              DOCKER,
+	"tcp://localhost:2375":           DOCKER,
+	"unix:///run/docker/docker.sock": DOCKER,
+}
+
+// DockerContainerdSocket is the socket of the containerd instance managed by docker,
+// which keeps docker's containers in the moby namespace
+const DockerContainerdSocket = "unix:///run/docker/containerd/containerd.sock"
+
+// CRISockets are well-known kubelet CRI sockets of runtimes not speaking CRI on their main socket
+var CRISockets = []string{
+	"/var/run/dockershim.sock",
+	"/var/run/cri-dockerd.sock",
+	"/var/run/crio/crio.sock",
+}
@@ -0,0 +1,12 @@
+//go:build darwin
+// +build darwin
+
+package constants
+
+// DockerDesktopRuntimes are the sockets of Docker Desktop on macOS relative to the running user's home
+// directory: the user socket of Docker Desktop 4.13 and later, and the raw socket of the VM's daemon, which
+// earlier releases symlink /var/run/docker.sock to
+var DockerDesktopRuntimes = map[string]string{
+	".docker/run/docker.sock":                                   DOCKER,
+	"Library/Containers/com.docker.docker/Data/docker.raw.sock": DOCKER,
+}
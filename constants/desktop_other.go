@@ -0,0 +1,7 @@
+//go:build !darwin
+// +build !darwin
+
+package constants
+
+// DockerDesktopRuntimes are the sockets of Docker Desktop relative to the home directory, only probed on macOS
+var DockerDesktopRuntimes = map[string]string{}
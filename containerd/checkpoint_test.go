@@ -0,0 +1,54 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"context"
+	"errors"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"testing"
+)
+
+// fakeImageStore is an images.Store recording deletes, answering them with err
+type fakeImageStore struct {
+	images.Store
+	deleted []string
+	err     error
+}
+
+func (s *fakeImageStore) Delete(ctx context.Context, name string, opts ...images.DeleteOpt) error {
+	s.deleted = append(s.deleted, name)
+	return s.err
+}
+
+func TestRemoveCheckpointRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      string
+		deleteErr   error
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{name: "runc image path checkpoint without record", record: ""},
+		{name: "record deleted", record: "checkpoint/abc", wantDeleted: true},
+		{name: "record already gone", record: "checkpoint/abc", deleteErr: errdefs.ErrNotFound, wantDeleted: true},
+		{name: "delete fails", record: "checkpoint/abc", deleteErr: errors.New("boom"), wantErr: true, wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeImageStore{err: tt.deleteErr}
+			err := removeCheckpointRecord(context.Background(), store, tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("removeCheckpointRecord() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && errdefs.IsNotFound(err) {
+				t.Errorf("removeCheckpointRecord() error %v reads as not found", err)
+			}
+			if deleted := len(store.deleted) > 0; deleted != tt.wantDeleted {
+				t.Errorf("deleted %v, want delete %v", store.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -1,11 +1,41 @@
+//go:build !no_containerd
+// +build !no_containerd
+
 package containerd
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	ctrd "github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/platforms"
+	reference "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/typeurl"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
+	"strings"
+	"time"
 )
 
 // New instantiates a new Containerd runtime object
@@ -15,6 +45,46 @@ func New() *Containerd {
 	}
 }
 
+// NewWithSocket instantiates a new Containerd runtime object using the given socket
+func NewWithSocket(socketPath string) *Containerd {
+	return &Containerd{
+		socketPath: socketPath,
+	}
+}
+
+// NewWithNamespace instantiates a new Containerd runtime object using the given socket, operating on
+// the given namespace instead of k8s.io. Docker stores the images of its containerd image store in
+// the moby namespace (constants.CONTAINERD_MOBY_NS).
+func NewWithNamespace(socketPath, namespace string) *Containerd {
+	return &Containerd{
+		socketPath: socketPath,
+		namespace:  namespace,
+	}
+}
+
+// NewWithSnapshotNamespace instantiates a new Containerd runtime object using the given socket and namespace,
+// looking up the snapshots of containers in snapshotNamespace for GetContainerRootfsPath and GetContainerDiff.
+// Some clusters prepare snapshots and their leases under a service namespace distinct from the namespace the
+// containers are listed in, where looking the snapshot up by the container's namespace fails with
+// "key does not exist".
+func NewWithSnapshotNamespace(socketPath, namespace, snapshotNamespace string) *Containerd {
+	return &Containerd{
+		socketPath:        socketPath,
+		namespace:         namespace,
+		snapshotNamespace: snapshotNamespace,
+	}
+}
+
+// NewWithConn instantiates a new Containerd runtime object over an existing grpc connection to containerd,
+// for agents which already keep one. The connection is never closed by the runtime object. There is no socket
+// to pass to nerdctl, so the methods shelling out to it, like ExtractImage and Save, use nerdctl's default socket.
+func NewWithConn(conn *grpc.ClientConn, namespace string) *Containerd {
+	return &Containerd{
+		conn:      conn,
+		namespace: namespace,
+	}
+}
+
 // GetSocket is socket getter
 func (c Containerd) GetSocket() string {
 	return c.socketPath
@@ -27,8 +97,17 @@ func (c Containerd) GetSocket() string {
 // skopeo copy oci:///home/ubuntu/img/docker/threatmapper_containerd-dir \
 // docker-archive:/home/ubuntu/img/docker/threatmapper_containerd.tar
 func (c Containerd) ExtractImage(imageID, imageName, path string) error {
+	return c.ExtractImageWithOptions(imageID, imageName, path, models.ExtractOptions{})
+}
+
+// saveImage extracts the OCI layout nerdctl saves the image as into path
+func (c Containerd) saveImage(imageName, path string) error {
+	imageName, err := c.resolveImageName(imageName)
+	if err != nil {
+		return err
+	}
 	var stderr bytes.Buffer
-	save := exec.Command("/usr/local/bin/nerdctl", "save", imageName)
+	save := exec.Command("/usr/local/bin/nerdctl", c.nerdctlArgs("save", imageName)...)
 	save.Stderr = &stderr
 	extract := exec.Command("tar", "xf", "-", "--warning=none", "-C"+path)
 	extract.Stderr = &stderr
@@ -54,58 +133,492 @@ func (c Containerd) ExtractImage(imageID, imageName, path string) error {
 	if err != nil {
 		return errors.New(stderr.String())
 	}
+	return nil
+}
+
+// ExtractImageWithProgress is ExtractImage reporting progress as the image tarball is extracted
+func (c Containerd) ExtractImageWithProgress(imageID, imageName, path string, progress models.ProgressFunc) error {
+	return c.ExtractImageWithOptions(imageID, imageName, path, models.ExtractOptions{Progress: progress})
+}
 
-	err = migrateOCIToDockerV1(path, imageID, "")
+// ExtractImageWithOptions is ExtractImage with progress reporting, digest verification, extraction limits and
+// a work dir for the docker archive the OCI layout is migrated through
+func (c Containerd) ExtractImageWithOptions(imageID, imageName, path string, opts models.ExtractOptions) error {
+	resolvedName, err := c.resolveImageName(imageName)
+	if err != nil {
+		return err
+	}
+	// nerdctl reads the image's blobs while containerd's garbage collector may run
+	defer c.leaseImage(resolvedName, c.namespaceOr(""))()
+	err = c.extractImage(resolvedName, path, opts)
 	if err != nil {
 		return err
 	}
+	err = utils.MigrateOCIToDockerV1InWorkDir(path, imageID, opts.WorkDir)
+	if err != nil {
+		return err
+	}
+	if opts.VerifyDigests {
+		return utils.VerifyImageDigests(path)
+	}
 	return nil
 }
 
-// GetImageID returns the image id
+// extractImage extracts the image tarball, reporting progress and enforcing the limits when set
+func (c Containerd) extractImage(imageName, path string, opts models.ExtractOptions) error {
+	if opts.Progress == nil && opts.MaxFiles == 0 && opts.MaxBytes == 0 {
+		return c.saveImage(imageName, path)
+	}
+	imageName, err := c.resolveImageName(imageName)
+	if err != nil {
+		return err
+	}
+	var total int64
+	if clientd, err := c.newClient(); err == nil {
+		ctx := namespaces.WithNamespace(context.Background(), c.namespaceOr(""))
+		if image, err := clientd.GetImage(ctx, imageName); err == nil {
+			total, _ = image.Size(ctx)
+		}
+		c.closeClient(clientd)
+	}
+
+	var stderr bytes.Buffer
+	save := exec.Command("/usr/local/bin/nerdctl", c.nerdctlArgs("save", imageName)...)
+	save.Stderr = &stderr
+	imageTar, err := save.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	extract := exec.Command("tar", "xf", "-", "--warning=none", "-C"+path)
+	extract.Stderr = &stderr
+	pipe, err := extract.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	err = extract.Start()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	err = save.Start()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	err = utils.CopyImageTar(pipe, imageTar, total, opts)
+	if err != nil {
+		pipe.Close()
+		save.Wait()
+		extract.Wait()
+		return err
+	}
+	err = save.Wait()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	err = pipe.Close()
+	if err != nil {
+		return err
+	}
+	err = extract.Wait()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	return nil
+}
+
+// ExtractFlattenedFS extracts the image and merges its layers into a single filesystem tar at outputTarPath with
+// the whiteouts applied, see utils.FlattenLayers
+func (c Containerd) ExtractFlattenedFS(imageName, outputTarPath string) error {
+	return c.ExtractFlattenedFSWithOptions(imageName, outputTarPath, models.ExtractOptions{})
+}
+
+// ExtractFlattenedFSWithOptions is ExtractFlattenedFS with the options of ExtractImageWithOptions, the MaxFiles
+// and MaxBytes limits apply to both the extraction and the flattened tar
+func (c Containerd) ExtractFlattenedFSWithOptions(imageName, outputTarPath string, opts models.ExtractOptions) error {
+	imageID, err := c.GetImageID(imageName)
+	if err != nil {
+		return err
+	}
+	return utils.ExtractFlattened(outputTarPath, opts, func(dir string) error {
+		return c.ExtractImageWithOptions(strings.TrimSpace(string(imageID)), imageName, dir, opts)
+	})
+}
+
+// ExtractImageStream streams the layers of the image for the host platform in image order, one at a time,
+// reading each layer blob from the content store as it is consumed. Digest is the diff id of the layer, the
+// digest of the uncompressed tar as listed in the image config, like docker reports it.
+func (c Containerd) ExtractImageStream(ctx context.Context, imageName, namespace string) (<-chan models.LayerResult, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace = c.namespaceOr(namespace)
+	ctx = namespaces.WithNamespace(ctx, namespace)
+	image, err := clientd.GetImage(ctx, NormalizeImageRef(imageName))
+	if err != nil {
+		c.closeClient(clientd)
+		return nil, err
+	}
+	release := leaseImageContent(ctx, clientd, image)
+	store := clientd.ContentStore()
+	manifest, err := images.Manifest(ctx, store, image.Target(), platforms.Default())
+	if err != nil {
+		release()
+		c.closeClient(clientd)
+		return nil, err
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err == nil && len(diffIDs) != len(manifest.Layers) {
+		err = fmt.Errorf("image %s has %d layers but %d diff ids", imageName, len(manifest.Layers), len(diffIDs))
+	}
+	if err != nil {
+		release()
+		c.closeClient(clientd)
+		return nil, err
+	}
+	layers := make(chan models.LayerResult)
+	go func() {
+		defer close(layers)
+		defer c.closeClient(clientd)
+		defer release()
+		for i, desc := range manifest.Layers {
+			if err := streamLayer(ctx, store, desc, diffIDs[i].String(), layers); err != nil {
+				if ctx.Err() == nil {
+					utils.SendLayerError(ctx, layers, err)
+				}
+				return
+			}
+		}
+	}()
+	return layers, nil
+}
+
+// streamLayer sends the uncompressed tar of the layer blob on layers as the diff id, returning once it has been consumed
+func streamLayer(ctx context.Context, store content.Store, desc ocispec.Descriptor, diffID string, layers chan<- models.LayerResult) error {
+	blob, err := store.ReaderAt(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	layer, err := compression.DecompressStream(content.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	return utils.SendLayer(ctx, layers, diffID, layer)
+}
+
+// OpenLayer opens the uncompressed tar of the image's layer for random access, for reading a file at a known
+// offset without streaming the whole layer. layerDigest is a diff id of the image config, as reported by
+// ExtractImageStream. Uncompressed layer blobs are read from the content store as stored, compressed ones are
+// decompressed to a temporary file first. Closing the returned reader releases the content store reader and the
+// client, or removes the temporary file.
+func (c Containerd) OpenLayer(imageName, layerDigest string) (models.LayerReader, int64, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx := namespaceContext(c.namespaceOr(""))
+	image, err := clientd.GetImage(ctx, NormalizeImageRef(imageName))
+	if err != nil {
+		c.closeClient(clientd)
+		return nil, 0, err
+	}
+	store := clientd.ContentStore()
+	manifest, err := images.Manifest(ctx, store, image.Target(), platforms.Default())
+	if err != nil {
+		c.closeClient(clientd)
+		return nil, 0, err
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		c.closeClient(clientd)
+		return nil, 0, err
+	}
+	for i, diffID := range diffIDs {
+		if diffID.String() != layerDigest || i >= len(manifest.Layers) {
+			continue
+		}
+		desc := manifest.Layers[i]
+		blob, err := store.ReaderAt(ctx, desc)
+		if err != nil {
+			c.closeClient(clientd)
+			return nil, 0, err
+		}
+		if desc.Digest == diffID {
+			// the blob is the uncompressed tar
+			return &layerReaderAt{ReaderAt: blob, close: func() { c.closeClient(clientd) }}, blob.Size(), nil
+		}
+		layer, size, err := decompressLayer(blob)
+		blob.Close()
+		c.closeClient(clientd)
+		if err != nil {
+			return nil, 0, fmt.Errorf("layer %s of image %s: %v", layerDigest, imageName, err)
+		}
+		return layer, size, nil
+	}
+	c.closeClient(clientd)
+	return nil, 0, fmt.Errorf("layer %s not found in image %s", layerDigest, imageName)
+}
+
+// layerReaderAt is a content store reader which also closes the client it was opened with
+type layerReaderAt struct {
+	content.ReaderAt
+	close func()
+}
+
+func (r *layerReaderAt) Close() error {
+	err := r.ReaderAt.Close()
+	r.close()
+	return err
+}
+
+// layerFile is the temporary file a compressed layer was decompressed to, closing it removes the file
+type layerFile struct {
+	*os.File
+}
+
+func (l *layerFile) Close() error {
+	err := l.File.Close()
+	os.Remove(l.Name())
+	return err
+}
+
+// decompressLayer decompresses the layer blob to a temporary file, returning it along with its size
+func decompressLayer(blob content.ReaderAt) (*layerFile, int64, error) {
+	layer, err := compression.DecompressStream(content.NewReader(blob))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer layer.Close()
+	f, err := ioutil.TempFile("", "vessel-layer-")
+	if err != nil {
+		return nil, 0, err
+	}
+	file := &layerFile{File: f}
+	size, err := io.Copy(f, layer)
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to decompress layer: %v", err)
+	}
+	return file, size, nil
+}
+
+// GetImageID returns the image id. Images are matched on their name first and then on their digest,
+// since on kubernetes nodes many images in the k8s.io namespace only have digest references, and last
+// on a unique digest prefix so short image ids work too.
 func (c Containerd) GetImageID(imageName string) ([]byte, error) {
-	return exec.Command("/usr/local/bin/nerdctl", "images", "-q", "--no-trunc", imageName).Output()
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(""))
+	imageList, err := clientd.ImageService().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	image, ok := findImage(imageList, imageName)
+	if !ok {
+		if matches := imagesWithIDPrefix(imageList, imageName); len(matches) > 1 {
+			return nil, fmt.Errorf("image id %s is ambiguous, it matches %s", imageName, strings.Join(matches, ", "))
+		}
+		return nil, fmt.Errorf("image %s not found", imageName)
+	}
+	return []byte(image.Target.Digest.String() + "\n"), nil
 }
 
-// Save just saves image using -o flag
-func (c Containerd) Save(imageName, outputParam string) ([]byte, error) {
-	return exec.Command("/usr/local/bin/nerdctl", "-n", "k8s.io", "save", "-o", outputParam, imageName).Output()
+// GetImageIDs returns the image ids of the named images using a single image store listing,
+// names that don't resolve to an image map to an empty id
+func (c Containerd) GetImageIDs(names []string) (map[string]string, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	imageList, err := clientd.ImageService().List(namespaceContext(c.namespaceOr("")))
+	if err != nil {
+		return nil, err
+	}
+	imageIDs := make(map[string]string, len(names))
+	for _, name := range names {
+		imageIDs[name] = ""
+		if image, ok := findImage(imageList, name); ok {
+			imageIDs[name] = image.Target.Digest.String()
+		}
+	}
+	return imageIDs, nil
 }
 
-// migrateOCIToDockerV1 migrates OCI image to Docker v1 image tarball
-func migrateOCIToDockerV1(path, imageID, tarFilePath string) error {
-	if tarFilePath == "" {
-		tarFilePath = path + imageID + ".tar"
+// ListImages returns the images of the namespace. containerd keeps a record per image name,
+// records sharing a target digest are reported as one image with several repo tags. The filters are applied
+// client side to the image names.
+func (c Containerd) ListImages(namespace string, opts models.ListImagesOptions) ([]models.ImageInfo, error) {
+	nameFilter, err := utils.NewImageNameFilter(opts.Pattern, opts.Regexp)
+	if err != nil {
+		return nil, err
 	}
-	sourceDir := "oci://" + path
-	destinationTar := "docker-archive:" + tarFilePath
-	var stderr bytes.Buffer
+	var repository string
+	if opts.Repository != "" {
+		named, err := reference.ParseNormalizedNamed(opts.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository filter %q: %v", opts.Repository, err)
+		}
+		repository = named.Name()
+	}
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
 
-	// skopeo will convert oci dir into docker v1 tarball
-	skopeoCopy := exec.Command("/usr/bin/skopeo", "copy", sourceDir, destinationTar)
-	skopeoCopy.Stderr = &stderr
-	err := skopeoCopy.Run()
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	imageList, err := clientd.ImageService().List(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to migrate OCI to Docker image: %v", stderr)
+		return nil, err
+	}
+	var imageInfos []models.ImageInfo
+	byDigest := make(map[string]int)
+	for _, image := range imageList {
+		named, err := reference.ParseNormalizedNamed(image.Name)
+		if repository != "" && (err != nil || named.Name() != repository) {
+			continue
+		}
+		if !nameFilter.IsEmpty() && (strings.HasPrefix(image.Name, "sha256:") || !nameFilter.Matches(image.Name)) {
+			continue
+		}
+		imageID := image.Target.Digest.String()
+		i, ok := byDigest[imageID]
+		if !ok {
+			size, _ := ctrd.NewImage(clientd, image).Size(ctx)
+			imageInfos = append(imageInfos, models.ImageInfo{
+				ID:       imageID,
+				RepoTags: []string{},
+				Size:     size,
+				Created:  image.CreatedAt,
+			})
+			i = len(imageInfos) - 1
+			byDigest[imageID] = i
+		}
+		// the cri plugin also records images under their bare config digest, which isn't a tag
+		if _, isTagged := named.(reference.Tagged); err == nil && isTagged && !strings.HasPrefix(image.Name, "sha256:") {
+			imageInfos[i].RepoTags = append(imageInfos[i].RepoTags, image.Name)
+		}
+	}
+	return imageInfos, nil
+}
+
+// findImage returns the image named imageName, or else the image whose digest matches
+// imageName given as a digest (sha256:...) or a digest reference (repo@sha256:...)
+func findImage(imageList []images.Image, imageName string) (images.Image, bool) {
+	normalized := NormalizeImageRef(imageName)
+	for _, image := range imageList {
+		if image.Name == imageName || image.Name == normalized {
+			return image, true
+		}
+	}
+	imageDigest := imageName
+	if i := strings.LastIndex(imageName, "@"); i >= 0 {
+		imageDigest = imageName[i+1:]
+	}
+	for _, image := range imageList {
+		if image.Target.Digest.String() == imageDigest {
+			return image, true
+		}
+		if i := strings.LastIndex(image.Name, "@"); i >= 0 && image.Name[i+1:] == imageDigest {
+			return image, true
+		}
+	}
+	// a short image id, like the ones docker shows, matches the image whose digest it is a prefix of
+	if matches := imagesWithIDPrefix(imageList, imageName); len(matches) == 1 {
+		for _, image := range imageList {
+			if image.Target.Digest.String() == matches[0] {
+				return image, true
+			}
+		}
 	}
+	return images.Image{}, false
+}
+
+// imagesWithIDPrefix returns the distinct image digests the short image id is a prefix of,
+// none when imageName doesn't look like an image id
+func imagesWithIDPrefix(imageList []images.Image, imageName string) []string {
+	if !isImageIDPrefix(imageName) {
+		return nil
+	}
+	prefix := "sha256:" + strings.TrimPrefix(imageName, "sha256:")
+	var matches []string
+	seen := make(map[string]bool)
+	for _, image := range imageList {
+		imageDigest := image.Target.Digest.String()
+		if strings.HasPrefix(imageDigest, prefix) && !seen[imageDigest] {
+			seen[imageDigest] = true
+			matches = append(matches, imageDigest)
+		}
+	}
+	return matches
+}
+
+// isImageIDPrefix reports whether the reference is a possibly shortened sha256 image id
+func isImageIDPrefix(ref string) bool {
+	hex := strings.TrimPrefix(ref, "sha256:")
+	if hex == "" || len(hex) > 64 {
+		return false
+	}
+	for _, r := range hex {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
 
-	// untar the docker archive
-	tarxf := exec.Command("tar", "xf", tarFilePath, "--warning=none", "-C"+path)
-	tarxf.Stderr = &stderr
-	err = tarxf.Run()
+// resolveImageName returns the digest of the image a short image id refers to, nerdctl only takes full
+// digests while NormalizeImageRef would turn the id into a docker hub repository name. Other
+// references are returned normalized.
+func (c Containerd) resolveImageName(imageName string) (string, error) {
+	if !isImageIDPrefix(imageName) {
+		return NormalizeImageRef(imageName), nil
+	}
+	imageID, err := c.GetImageID(imageName)
 	if err != nil {
-		return fmt.Errorf("failed to migrate OCI to Docker image: %v", err)
+		return "", err
 	}
+	return strings.TrimSpace(string(imageID)), nil
+}
 
-	// delete docker tar, not required
-	removeTar := exec.Command("rm", tarFilePath)
-	removeTar.Stderr = &stderr
-	err = removeTar.Run()
+// NormalizeImageRef returns the fully qualified form containerd stores image names in,
+// like docker.io/library/nginx:latest for nginx. Digests and unparsable references are returned as is.
+func NormalizeImageRef(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
 	if err != nil {
-		return fmt.Errorf("failed to delete generated docker-archive: %v", err)
+		return ref
 	}
+	return reference.TagNameOnly(named).String()
+}
 
-	return nil
+// Save just saves image using -o flag
+func (c Containerd) Save(imageName, outputParam string) ([]byte, error) {
+	defer c.leaseImage(NormalizeImageRef(imageName), c.namespaceOr(""))()
+	return exec.Command("/usr/local/bin/nerdctl", c.nerdctlArgs("save", "-o", outputParam, imageName)...).Output()
+}
+
+// SaveCompressed saves the image to outputPath with the given compression,
+// returning the output path with the compression's file extension
+func (c Containerd) SaveCompressed(imageName, outputPath string, compression models.Compression) (string, error) {
+	if compression == models.CompressionNone {
+		_, err := c.Save(imageName, outputPath)
+		return outputPath, err
+	}
+	defer c.leaseImage(NormalizeImageRef(imageName), c.namespaceOr(""))()
+	outputPath = utils.CompressedOutputPath(outputPath, compression)
+	save := exec.Command("/usr/local/bin/nerdctl", c.nerdctlArgs("save", imageName)...)
+	return outputPath, utils.SaveCompressed(save, outputPath, compression)
 }
 
 // fileuploader specific
@@ -141,3 +654,806 @@ func MigrateOCITarToDockerV1Tar(dir, tarName string) error {
 	return nil
 
 }
+
+// GetContainerDiff returns the files changed in the container's writable layer relative to its image,
+// by mounting the container snapshot and a view of its parent and comparing the two trees
+func (c Containerd) GetContainerDiff(containerID, namespace string) ([]models.ChangedFile, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSnapshotter(containerID, info.Snapshotter); err != nil {
+		return nil, err
+	}
+	ctx = c.snapshotContext(ctx)
+	snapshotter := clientd.SnapshotService(info.Snapshotter)
+	snapshot, err := snapshotter.Stat(ctx, info.SnapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := snapshotter.Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	viewKey := info.SnapshotKey + "-vessel-diff"
+	// a view left behind by an interrupted diff would make creating the view fail
+	snapshotter.Remove(ctx, viewKey)
+	lower, err := snapshotter.View(ctx, viewKey, snapshot.Parent)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotter.Remove(ctx, viewKey)
+
+	var changedFiles []models.ChangedFile
+	err = withReadOnlyMount(lower, "diff-lower", func(lowerRoot string) error {
+		return withReadOnlyMount(upper, "diff-upper", func(upperRoot string) error {
+			return fs.Changes(ctx, lowerRoot, upperRoot, func(kind fs.ChangeKind, changedPath string, _ os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if changeKind, ok := changeKinds[kind]; ok {
+					changedFiles = append(changedFiles, models.ChangedFile{Path: changedPath, Kind: changeKind})
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff container snapshot: %v", err)
+	}
+	return changedFiles, nil
+}
+
+// PullImage pulls and unpacks the image into the given namespace
+func (c Containerd) PullImage(imageRef, namespace string, opts models.PullOptions) error {
+	resolver, err := newResolver(opts)
+	if err != nil {
+		return err
+	}
+	clientd, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	defer c.closeClient(clientd)
+
+	_, err = clientd.Pull(namespaceContext(c.namespaceOr(namespace)), imageRef, ctrd.WithResolver(resolver), ctrd.WithPullUnpack)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %v", imageRef, err)
+	}
+	return nil
+}
+
+// SubscribeContainerEvents streams container and task lifecycle events of the namespace until ctx is cancelled
+func (c Containerd) SubscribeContainerEvents(ctx context.Context, namespace string) (<-chan models.Event, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace = c.namespaceOr(namespace)
+	ctx = namespaces.WithNamespace(ctx, namespace)
+	var eventFilters []string
+	for topic := range eventActions {
+		eventFilters = append(eventFilters, fmt.Sprintf(`namespace==%s,topic==%q`, namespace, topic))
+	}
+	envelopes, errs := clientd.Subscribe(ctx, eventFilters...)
+	containerEvents := make(chan models.Event)
+	go func() {
+		defer close(containerEvents)
+		defer c.closeClient(clientd)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-errs:
+				return
+			case envelope := <-envelopes:
+				payload, err := typeurl.UnmarshalAny(envelope.Event)
+				if err != nil {
+					continue
+				}
+				event := models.Event{
+					ID:        eventContainerID(payload),
+					Action:    eventActions[envelope.Topic],
+					Timestamp: envelope.Timestamp,
+				}
+				select {
+				case containerEvents <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return containerEvents, nil
+}
+
+// eventContainerID returns the id of the container an event payload refers to
+func eventContainerID(payload interface{}) string {
+	switch e := payload.(type) {
+	case *apievents.ContainerCreate:
+		return e.ID
+	case *apievents.ContainerDelete:
+		return e.ID
+	case *apievents.TaskStart:
+		return e.ContainerID
+	case *apievents.TaskExit:
+		return e.ContainerID
+	case *apievents.TaskPaused:
+		return e.ContainerID
+	case *apievents.TaskResumed:
+		return e.ContainerID
+	}
+	return ""
+}
+
+// GetContainerRootfsPath mounts the container's rootfs read-only under a temp dir, returning the
+// mount path and a cleanup that unmounts and removes it. Mounts leaked by a crash before the cleanup
+// is called are removed by CleanupStaleMounts.
+func (c Containerd) GetContainerRootfsPath(containerID, namespace string) (string, func() error, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return "", nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = checkSnapshotter(containerID, info.Snapshotter); err != nil {
+		return "", nil, err
+	}
+	mounts, err := clientd.SnapshotService(info.Snapshotter).Mounts(c.snapshotContext(ctx), info.SnapshotKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mountReadOnly(mounts, "rootfs")
+}
+
+// GetImageRootfsPath mounts the flattened filesystem of the image for the host platform read-only under a temp
+// dir, returning the mount path and a cleanup that unmounts it. A view is taken of the committed snapshot of the
+// image's top layer in the namespace's snapshotter, unpacking the image first when it hasn't been unpacked.
+func (c Containerd) GetImageRootfsPath(imageRef, namespace string) (string, func() error, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return "", nil, err
+	}
+	defer c.closeClient(clientd)
+
+	namespace = c.namespaceOr(namespace)
+	ctx := namespaceContext(namespace)
+	imageRef, err = c.resolveImageName(imageRef)
+	if err != nil {
+		return "", nil, err
+	}
+	image, err := clientd.GetImage(ctx, imageRef)
+	if err != nil {
+		return "", nil, err
+	}
+	snapshotterName := ctrd.DefaultSnapshotter
+	if labels, err := clientd.NamespaceService().Labels(ctx, namespace); err == nil && labels[defaults.DefaultSnapshotterNSLabel] != "" {
+		snapshotterName = labels[defaults.DefaultSnapshotterNSLabel]
+	}
+	if err = checkSnapshotter(imageRef, snapshotterName); err != nil {
+		return "", nil, err
+	}
+	unpacked, err := image.IsUnpacked(ctx, snapshotterName)
+	if err != nil {
+		return "", nil, err
+	}
+	if !unpacked {
+		if err = image.Unpack(ctx, snapshotterName); err != nil {
+			return "", nil, fmt.Errorf("failed to unpack image %s: %v", imageRef, err)
+		}
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	snapshotter := clientd.SnapshotService(snapshotterName)
+	viewKey := fmt.Sprintf("%s-vessel-image-%d", identity.ChainID(diffIDs), time.Now().UnixNano())
+	mounts, err := snapshotter.View(ctx, viewKey, identity.ChainID(diffIDs).String())
+	if err != nil {
+		return "", nil, err
+	}
+	rootfsPath, unmount, err := mountReadOnly(mounts, "image")
+	if err != nil {
+		snapshotter.Remove(ctx, viewKey)
+		return "", nil, err
+	}
+	return rootfsPath, func() error {
+		err := unmount()
+		// the view outlives the client the image was mounted with
+		if viewClient, clientErr := c.newClient(); clientErr == nil {
+			viewClient.SnapshotService(snapshotterName).Remove(ctx, viewKey)
+			c.closeClient(viewClient)
+		}
+		return err
+	}, nil
+}
+
+// ExportImageFilesystem writes the flattened filesystem of the image to w as a tar archive, archiving a
+// read-only mount of the image's snapshot
+func (c Containerd) ExportImageFilesystem(imageRef, namespace string, w io.Writer) error {
+	rootfsPath, cleanup, err := c.GetImageRootfsPath(imageRef, namespace)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return utils.TarDirectory(rootfsPath, w, models.TarOptions{})
+}
+
+// readOnlyMounts converts snapshot mounts to read-only ones. Overlay mounts have their upperdir
+// stacked as the top lowerdir so the live container's upperdir isn't mounted a second time, the bind
+// mounts of native snapshots and btrfs and zfs subvolume mounts only have rw replaced by ro.
+func readOnlyMounts(mounts []mount.Mount) []mount.Mount {
+	roMounts := make([]mount.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		var options []string
+		if m.Type == "overlay" {
+			var upperDir, lowerDir string
+			for _, option := range m.Options {
+				switch {
+				case strings.HasPrefix(option, "upperdir="):
+					upperDir = strings.TrimPrefix(option, "upperdir=")
+				case strings.HasPrefix(option, "lowerdir="):
+					lowerDir = strings.TrimPrefix(option, "lowerdir=")
+				case strings.HasPrefix(option, "workdir="):
+				default:
+					options = append(options, option)
+				}
+			}
+			if upperDir != "" {
+				if lowerDir != "" {
+					lowerDir = upperDir + ":" + lowerDir
+				} else {
+					lowerDir = upperDir
+				}
+			}
+			options = append(options, "lowerdir="+lowerDir)
+		} else {
+			for _, option := range m.Options {
+				if option != "rw" {
+					options = append(options, option)
+				}
+			}
+		}
+		roMounts = append(roMounts, mount.Mount{
+			Type:    m.Type,
+			Source:  m.Source,
+			Options: append(options, "ro"),
+		})
+	}
+	return roMounts
+}
+
+// GetContainerStatus returns the normalized state of the container from its task
+func (c Containerd) GetContainerStatus(containerID, namespace string) (string, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return "", err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	state, _, err := taskState(ctx, container)
+	return state, err
+}
+
+// PauseContainer pauses the task of the running container, failing when it isn't running
+func (c Containerd) PauseContainer(containerID, namespace string) error {
+	clientd, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	task, state, err := loadTask(ctx, clientd, containerID)
+	if err != nil {
+		return err
+	}
+	if state != models.ContainerRunning {
+		return fmt.Errorf("container %s is not running, it is %s", containerID, state)
+	}
+	return task.Pause(ctx)
+}
+
+// UnpauseContainer resumes the task of the paused container, failing when it isn't paused
+func (c Containerd) UnpauseContainer(containerID, namespace string) error {
+	clientd, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	task, state, err := loadTask(ctx, clientd, containerID)
+	if err != nil {
+		return err
+	}
+	if state != models.ContainerPaused {
+		return fmt.Errorf("container %s is not paused, it is %s", containerID, state)
+	}
+	return task.Resume(ctx)
+}
+
+// loadTask returns the task of the container along with its normalized state,
+// a container without a task is reported as created
+func loadTask(ctx context.Context, clientd *ctrd.Client, containerID string) (ctrd.Task, string, error) {
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, "", err
+	}
+	state, _, err := taskState(ctx, container)
+	if err != nil || state == models.ContainerCreated {
+		return nil, state, err
+	}
+	task, err := container.Task(ctx, nil)
+	return task, state, err
+}
+
+// GetContainerInfo returns the name, image, creation and start times and normalized state of the container.
+// containerd doesn't record when tasks start, StartedAt is the start time of the task's init process
+// and is only known while the task runs.
+func (c Containerd) GetContainerInfo(containerID, namespace string) (*models.ContainerInfo, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := container.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state, pid, err := taskState(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	info := &models.ContainerInfo{
+		ID:      metadata.ID,
+		Name:    metadata.ID,
+		Image:   metadata.Image,
+		Created: metadata.CreatedAt,
+		State:   state,
+	}
+	if name := metadata.Labels["io.kubernetes.container.name"]; name != "" {
+		info.Name = name
+	}
+	if state == models.ContainerRunning || state == models.ContainerPaused {
+		info.StartedAt, _ = processStartTime(pid)
+	}
+	return info, nil
+}
+
+// taskState returns the normalized state of the container and the pid of its task,
+// a container without a task has not been started and is reported as created
+func taskState(ctx context.Context, container ctrd.Container) (string, uint32, error) {
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return models.ContainerCreated, 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	switch status.Status {
+	case ctrd.Running:
+		return models.ContainerRunning, task.Pid(), nil
+	case ctrd.Paused, ctrd.Pausing:
+		return models.ContainerPaused, task.Pid(), nil
+	case ctrd.Created:
+		return models.ContainerCreated, task.Pid(), nil
+	default:
+		return models.ContainerExited, task.Pid(), nil
+	}
+}
+
+// GetContainerPID returns the host pid of the init process of the container's task
+func (c Containerd) GetContainerPID(containerID, namespace string) (int, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return 0, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return 0, fmt.Errorf("container %s is not running", containerID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(task.Pid()), nil
+}
+
+// GetContainerMounts returns the host directories bind mounted into the container and its tmpfs mounts from its
+// oci spec. Binds of kubernetes pod volumes are reported as volumes, the proc, sysfs and device mounts and the
+// tmpfs mounts every container gets aren't user mounts so they are left out.
+func (c Containerd) GetContainerMounts(containerID, namespace string) ([]models.Mount, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []models.Mount
+	for _, specMount := range spec.Mounts {
+		mountType, source := "", specMount.Source
+		switch {
+		case isBindMount(specMount.Type, specMount.Options) && isKubeletVolume(specMount.Source):
+			mountType = models.MountVolume
+		case isBindMount(specMount.Type, specMount.Options):
+			mountType = models.MountBind
+		case specMount.Type == "tmpfs" && !runtimeTmpfs[specMount.Destination]:
+			// the source of a tmpfs is just its device name
+			mountType, source = models.MountTmpfs, ""
+		default:
+			continue
+		}
+		mounts = append(mounts, models.Mount{
+			Source:      source,
+			Destination: specMount.Destination,
+			Type:        mountType,
+			RW:          !hasOption(specMount.Options, "ro"),
+		})
+	}
+	return mounts, nil
+}
+
+// runtimeTmpfs are the tmpfs mounts of containerd's default spec, which aren't user mounts
+var runtimeTmpfs = map[string]bool{
+	"/dev":     true,
+	"/dev/shm": true,
+	"/run":     true,
+}
+
+// isKubeletVolume reports whether the host path is a volume of a kubernetes pod, which the kubelet
+// keeps under /var/lib/kubelet/pods/<uid>/volumes
+func isKubeletVolume(source string) bool {
+	parts := strings.Split(strings.TrimPrefix(source, "/var/lib/kubelet/pods/"), "/")
+	return strings.HasPrefix(source, "/var/lib/kubelet/pods/") && len(parts) >= 2 && parts[1] == "volumes"
+}
+
+// isBindMount reports whether an oci spec mount binds a host path, bind mounts may leave the type empty
+func isBindMount(mountType string, options []string) bool {
+	return mountType == "bind" || hasOption(options, "bind") || hasOption(options, "rbind")
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// GetContainerSpec returns the OCI runtime spec the container was created with, as stored by containerd
+func (c Containerd) GetContainerSpec(containerID, namespace string) (*oci.Spec, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	return container.Spec(ctx)
+}
+
+// CheckpointContainer checkpoints the container's running task with CRIU into outDir, leaving it running.
+// outDir is written by the runtime shim, so it is a path on the node containerd runs on.
+func (c Containerd) CheckpointContainer(containerID, namespace, outDir string) error {
+	clientd, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("container %s is not running", containerID)
+	}
+	if err != nil {
+		return err
+	}
+	checkpoint, err := task.Checkpoint(ctx, ctrd.WithCheckpointImagePath(outDir))
+	if err != nil {
+		return err
+	}
+	return removeCheckpointRecord(ctx, clientd.ImageService(), checkpoint.Name())
+}
+
+// removeCheckpointRecord deletes the image record containerd creates for a checkpoint, the checkpoint itself is
+// kept in the image path. runc checkpoints written to an image path have no record, their name is empty.
+func removeCheckpointRecord(ctx context.Context, store images.Store, name string) error {
+	if name == "" {
+		return nil
+	}
+	// a record that's already gone isn't a failure of the checkpoint, and mustn't read as a missing container
+	if err := store.Delete(ctx, name); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("checkpoint written, error removing its image record %s: %v", name, err)
+	}
+	return nil
+}
+
+// ListContainerIDs returns the ids of all containers in the namespace
+func (c Containerd) ListContainerIDs(namespace string) ([]string, error) {
+	return c.ListContainers(namespace, models.ListContainersOptions{})
+}
+
+// ListContainers returns the ids of the containers matching the options. containerd has no label selectors
+// of its own, so the labels of the container records are matched after listing them.
+func (c Containerd) ListContainers(namespace string, opts models.ListContainersOptions) ([]string, error) {
+	selector, err := utils.ParseLabelSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+	containers, err := clientd.ContainerService().List(namespaceContext(c.namespaceOr(namespace)))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		if selector.Matches(container.Labels) {
+			ids = append(ids, container.ID)
+		}
+	}
+	return ids, nil
+}
+
+// GetImageDigest returns the digest of the image's target descriptor, the manifest or index digest the
+// registry serves the image by
+func (c Containerd) GetImageDigest(imageRef, namespace string) (string, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return "", err
+	}
+	defer c.closeClient(clientd)
+
+	image, err := clientd.GetImage(namespaceContext(c.namespaceOr(namespace)), NormalizeImageRef(imageRef))
+	if err != nil {
+		return "", err
+	}
+	return image.Target().Digest.String(), nil
+}
+
+// GetImageLabels returns the labels of the image config, an empty map when it has none
+func (c Containerd) GetImageLabels(imageName string) (map[string]string, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(""))
+	image, err := clientd.GetImage(ctx, NormalizeImageRef(imageName))
+	if err != nil {
+		return nil, err
+	}
+	config, err := readImageConfig(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(config.Config.Labels))
+	for key, value := range config.Config.Labels {
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// readImageConfig reads the config of the image for the host platform from the content store
+func readImageConfig(ctx context.Context, image ctrd.Image) (ocispec.Image, error) {
+	var config ocispec.Image
+	configDesc, err := image.Config(ctx)
+	if err != nil {
+		return config, err
+	}
+	configBlob, err := content.ReadBlob(ctx, image.ContentStore(), configDesc)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(configBlob, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config of image %s: %v", image.Name(), err)
+	}
+	return config, nil
+}
+
+// GetImageLayers returns the ordered layer diff ids of the image from its config
+func (c Containerd) GetImageLayers(imageRef, namespace string) ([]string, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	image, err := clientd.GetImage(ctx, NormalizeImageRef(imageRef))
+	if err != nil {
+		return nil, err
+	}
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]string, 0, len(diffIDs))
+	for _, diffID := range diffIDs {
+		layers = append(layers, diffID.String())
+	}
+	return layers, nil
+}
+
+// GetImageHistory returns the build steps of the image oldest first from the history of its config,
+// each step creating a layer is given the next diff id of the config's rootfs
+func (c Containerd) GetImageHistory(imageRef, namespace string) ([]models.HistoryEntry, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	image, err := clientd.GetImage(ctx, NormalizeImageRef(imageRef))
+	if err != nil {
+		return nil, err
+	}
+	config, err := readImageConfig(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := config.RootFS.DiffIDs
+	entries := make([]models.HistoryEntry, 0, len(config.History))
+	for _, step := range config.History {
+		entry := models.HistoryEntry{
+			CreatedBy:  step.CreatedBy,
+			EmptyLayer: step.EmptyLayer,
+		}
+		if step.Created != nil {
+			entry.Created = *step.Created
+		}
+		if !step.EmptyLayer && len(diffIDs) > 0 {
+			entry.DiffID, diffIDs = diffIDs[0].String(), diffIDs[1:]
+		}
+		entries = append(entries, entry)
+	}
+	// images built without history still have their layers
+	for _, diffID := range diffIDs {
+		entries = append(entries, models.HistoryEntry{DiffID: diffID.String()})
+	}
+	return entries, nil
+}
+
+// GetContainerImage returns the image the container was created from,
+// the digest is the image's target descriptor digest
+func (c Containerd) GetContainerImage(containerID, namespace string) (models.ImageRef, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return models.ImageRef{}, err
+	}
+	defer c.closeClient(clientd)
+
+	ctx := namespaceContext(c.namespaceOr(namespace))
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return models.ImageRef{}, err
+	}
+	image, err := container.Image(ctx)
+	if err != nil {
+		return models.ImageRef{}, err
+	}
+	return models.ImageRef{
+		Name:   image.Name(),
+		Digest: image.Target().Digest.String(),
+	}, nil
+}
+
+// newClient creates a containerd client connected to the runtime socket
+func (c Containerd) newClient() (*ctrd.Client, error) {
+	if c.conn != nil {
+		return ctrd.NewWithConn(c.conn)
+	}
+	return ctrd.New(strings.Replace(c.socketPath, "unix://", "", 1))
+}
+
+// closeClient closes a client created by newClient, unless it runs over the caller's connection
+func (c Containerd) closeClient(clientd *ctrd.Client) {
+	if c.conn == nil {
+		clientd.Close()
+	}
+}
+
+// namespaceOr returns namespace, falling back to the namespace of the runtime object and then to k8s.io.
+// Every method resolves its namespace with it, so the image an id was looked up for is the one nerdctl extracts.
+func (c Containerd) namespaceOr(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if c.namespace != "" {
+		return c.namespace
+	}
+	return constants.CONTAINERD_K8S_NS
+}
+
+// snapshotContext returns ctx switched to the snapshot namespace of the runtime object when set
+func (c Containerd) snapshotContext(ctx context.Context) context.Context {
+	if c.snapshotNamespace == "" {
+		return ctx
+	}
+	return namespaces.WithNamespace(ctx, c.snapshotNamespace)
+}
+
+// nerdctlArgs prefixes the nerdctl arguments with the socket and the namespace of the runtime object, see
+// namespaceOr, so nerdctl saves from the daemon the image was looked up on
+func (c Containerd) nerdctlArgs(args ...string) []string {
+	var global []string
+	if c.socketPath != "" {
+		global = append(global, "--address", strings.Replace(c.socketPath, "unix://", "", 1))
+	}
+	return append(append(global, "-n", c.namespaceOr("")), args...)
+}
+
+// namespaceContext returns a context for the given containerd namespace, k8s.io when not set
+func namespaceContext(namespace string) context.Context {
+	if namespace == "" {
+		namespace = constants.CONTAINERD_K8S_NS
+	}
+	return namespaces.WithNamespace(context.Background(), namespace)
+}
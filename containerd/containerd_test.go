@@ -0,0 +1,220 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNonDefaultNamespace(t *testing.T) {
+	fake := newFakeContainerd(t)
+	// docker stores its containerd backed images in the moby namespace
+	mobyID := fake.addImage(t, "moby", nil, "docker.io/library/nginx:latest")
+	k8sID := fake.addImage(t, "k8s.io", map[string]string{"k8s": "true"}, "docker.io/library/nginx:latest")
+
+	imageID, err := fake.runtime("moby").GetImageID("nginx")
+	if err != nil {
+		t.Fatalf("GetImageID() in the moby namespace error = %v", err)
+	}
+	if strings.TrimSpace(string(imageID)) != mobyID {
+		t.Errorf("GetImageID() in the moby namespace = %s, want %s", imageID, mobyID)
+	}
+
+	// the namespace of an operation takes precedence over the runtime's
+	runtime := fake.runtime("")
+	for namespace, want := range map[string]string{"moby": mobyID, "k8s.io": k8sID, "": k8sID} {
+		digest, err := runtime.GetImageDigest("nginx", namespace)
+		if err != nil {
+			t.Fatalf("GetImageDigest(nginx, %q) error = %v", namespace, err)
+		}
+		if digest != want {
+			t.Errorf("GetImageDigest(nginx, %q) = %s, want %s", namespace, digest, want)
+		}
+	}
+
+	if _, err = fake.runtime("moby").GetImageID("redis"); err == nil {
+		t.Error("GetImageID() found an image missing from the moby namespace")
+	}
+}
+
+func TestGetImageIDDigestOnlyEntry(t *testing.T) {
+	fake := newFakeContainerd(t)
+	// kubelet pulls by digest, leaving the k8s.io namespace with only a digest reference to the image
+	manifest := fake.addImage(t, "k8s.io", nil)
+	fake.addRecord("k8s.io", ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(manifest)},
+		"k8s.gcr.io/pause@"+manifest)
+
+	runtime := fake.runtime("")
+	for _, ref := range []string{"k8s.gcr.io/pause@" + manifest, manifest, "registry.k8s.io/pause@" + manifest} {
+		imageID, err := runtime.GetImageID(ref)
+		if err != nil {
+			t.Fatalf("GetImageID(%s) error = %v", ref, err)
+		}
+		if strings.TrimSpace(string(imageID)) != manifest {
+			t.Errorf("GetImageID(%s) = %s, want %s", ref, imageID, manifest)
+		}
+	}
+	if _, err := runtime.GetImageID("k8s.gcr.io/pause:3.5"); err == nil {
+		t.Error("GetImageID() resolved a tag the namespace has no reference for")
+	}
+}
+
+func TestNamespaceResolution(t *testing.T) {
+	tests := []struct {
+		runtime   *Containerd
+		namespace string
+		want      string
+	}{
+		{runtime: NewWithSocket(""), want: "k8s.io"},
+		{runtime: NewWithSocket(""), namespace: "moby", want: "moby"},
+		{runtime: NewWithNamespace("", "moby"), want: "moby"},
+		{runtime: NewWithNamespace("", "moby"), namespace: "default", want: "default"},
+	}
+	for _, tt := range tests {
+		if got := tt.runtime.namespaceOr(tt.namespace); got != tt.want {
+			t.Errorf("namespaceOr(%q) of a runtime in %q = %q, want %q", tt.namespace, tt.runtime.namespace, got, tt.want)
+		}
+		// nerdctl extracts from the namespace image ids are looked up in
+		if args := tt.runtime.nerdctlArgs("save"); tt.namespace == "" && (args[0] != "-n" || args[1] != tt.want) {
+			t.Errorf("nerdctlArgs() of a runtime in %q = %v, want namespace %q", tt.runtime.namespace, args, tt.want)
+		}
+	}
+}
+
+func TestNerdctlArgs(t *testing.T) {
+	tests := []struct {
+		runtime *Containerd
+		want    []string
+	}{
+		{runtime: New(), want: []string{"--address", "/run/containerd/containerd.sock", "-n", "k8s.io", "save"}},
+		{runtime: NewWithNamespace("/run/k3s/containerd/containerd.sock", "moby"), want: []string{"--address", "/run/k3s/containerd/containerd.sock", "-n", "moby", "save"}},
+		{runtime: NewWithConn(nil, "moby"), want: []string{"-n", "moby", "save"}},
+	}
+	for _, tt := range tests {
+		if got := tt.runtime.nerdctlArgs("save"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nerdctlArgs() of a runtime on %q = %v, want %v", tt.runtime.socketPath, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeImageRef(t *testing.T) {
+	manifest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.21", "docker.io/library/nginx:1.21"},
+		{"library/nginx", "docker.io/library/nginx:latest"},
+		{"docker.io/nginx", "docker.io/library/nginx:latest"},
+		{"docker.io/library/nginx:latest", "docker.io/library/nginx:latest"},
+		{"bitnami/redis:6.2", "docker.io/bitnami/redis:6.2"},
+		{"quay.io/coreos/etcd", "quay.io/coreos/etcd:latest"},
+		{"localhost:5000/app:v1", "localhost:5000/app:v1"},
+		{"nginx@" + manifest, "docker.io/library/nginx@" + manifest},
+		{manifest, manifest},
+		{"Invalid:Ref:", "Invalid:Ref:"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeImageRef(tt.ref); got != tt.want {
+			t.Errorf("NormalizeImageRef(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestGetImageLayersShortName(t *testing.T) {
+	fake := newFakeContainerd(t)
+	fake.addImage(t, "k8s.io", nil, "docker.io/library/nginx:latest")
+
+	runtime := fake.runtime("")
+	want, err := runtime.GetImageLayers("docker.io/library/nginx:latest", "")
+	if err != nil {
+		t.Fatalf("GetImageLayers() of the fully qualified name error = %v", err)
+	}
+	got, err := runtime.GetImageLayers("nginx", "")
+	if err != nil {
+		t.Fatalf("GetImageLayers(nginx) error = %v", err)
+	}
+	if len(got) != 1 || got[0] != want[0] {
+		t.Errorf("GetImageLayers(nginx) = %v, want %v", got, want)
+	}
+}
+
+func TestImagesWithIDPrefix(t *testing.T) {
+	nginx := digest.Digest("sha256:4cdc5dd7eaad2f3c4d6c8cb0ca5a8cce3e0e0bd9c1ae0ab34b7c7b59a1d7a2f0")
+	redis := digest.Digest("sha256:4cdc9a8d66f1e3d3c1b0e2b6a9f8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0")
+	imageList := []images.Image{
+		{Name: "docker.io/library/nginx:latest", Target: ocispec.Descriptor{Digest: nginx}},
+		// the same image under a second name is one match
+		{Name: "docker.io/library/nginx:1.21", Target: ocispec.Descriptor{Digest: nginx}},
+		{Name: "docker.io/library/redis:6", Target: ocispec.Descriptor{Digest: redis}},
+	}
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{ref: "4cdc5dd7eaad", want: []string{nginx.String()}},
+		{ref: "sha256:4cdc5dd7eaad", want: []string{nginx.String()}},
+		{ref: "4cdc", want: []string{nginx.String(), redis.String()}},
+		{ref: "deadbeef"},
+		// names aren't id prefixes, even when they only have hex characters
+		{ref: "nginx"},
+		{ref: "cafe:latest"},
+	}
+	for _, tt := range tests {
+		if got := imagesWithIDPrefix(imageList, tt.ref); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("imagesWithIDPrefix(%s) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestOpenLayerByDiffID(t *testing.T) {
+	fake := newFakeContainerd(t)
+	uncompressed := []byte("uncompressed layer tar")
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("compressed layer tar"))
+	gw.Close()
+	layers := []ocispec.Descriptor{
+		fake.addRawBlob(ocispec.MediaTypeImageLayer, uncompressed),
+		fake.addRawBlob(ocispec.MediaTypeImageLayerGzip, gzipped.Bytes()),
+	}
+	diffIDs := []digest.Digest{layers[0].Digest, digest.FromString("compressed layer tar")}
+	config := fake.addBlob(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       ocispec.RootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	manifest := fake.addBlob(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{Config: config, Layers: layers})
+	fake.addRecord("k8s.io", manifest, "docker.io/library/app:latest")
+
+	runtime := fake.runtime("")
+	for i, want := range []string{"uncompressed layer tar", "compressed layer tar"} {
+		layer, size, err := runtime.OpenLayer("app", diffIDs[i].String())
+		if err != nil {
+			t.Fatalf("OpenLayer(%s) error = %v", diffIDs[i], err)
+		}
+		got := make([]byte, size)
+		_, err = layer.ReadAt(got, 0)
+		layer.Close()
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("OpenLayer(%s) = %q, want the uncompressed tar %q", diffIDs[i], got, want)
+		}
+	}
+	// the blob digest of a compressed layer isn't a diff id
+	if _, _, err := runtime.OpenLayer("app", layers[1].Digest.String()); err == nil {
+		t.Error("OpenLayer() opened a layer by its compressed blob digest")
+	}
+}
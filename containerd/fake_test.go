@@ -0,0 +1,292 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeContainerd is an in-process containerd daemon serving the images, content and leases services from
+// memory, images are kept per namespace while the blobs are shared like in containerd's content store
+type fakeContainerd struct {
+	mu     sync.Mutex
+	images map[string][]imagesapi.Image
+	blobs  map[digest.Digest][]byte
+	// leases maps the lease ids to the digests of the content they hold
+	leases map[string]map[string]bool
+	nextID int
+
+	conn *grpc.ClientConn
+}
+
+// newFakeContainerd starts a fake containerd on a unix socket of a temp dir, stopped when the test ends
+func newFakeContainerd(t *testing.T) *fakeContainerd {
+	f := &fakeContainerd{
+		images: map[string][]imagesapi.Image{},
+		blobs:  map[digest.Digest][]byte{},
+		leases: map[string]map[string]bool{},
+	}
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	imagesapi.RegisterImagesServer(server, &fakeImages{f: f})
+	contentapi.RegisterContentServer(server, &fakeContent{f: f})
+	leasesapi.RegisterLeasesServer(server, &fakeLeases{f: f})
+	go server.Serve(listener)
+	f.conn, err = grpc.Dial("unix://"+socket, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.conn.Close()
+		server.Stop()
+	})
+	return f
+}
+
+// runtime returns a runtime connected to the fake, operating on namespace by default
+func (f *fakeContainerd) runtime(namespace string) *Containerd {
+	return NewWithConn(f.conn, namespace)
+}
+
+// addBlob stores the json of v as a blob, returning its descriptor
+func (f *fakeContainerd) addBlob(t *testing.T, mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.addRawBlob(mediaType, data)
+}
+
+// addRawBlob stores data as a blob, returning its descriptor
+func (f *fakeContainerd) addRawBlob(mediaType string, data []byte) ocispec.Descriptor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := digest.FromBytes(data)
+	f.blobs[d] = data
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+// addImage stores a single layer image with the config labels under the names in namespace,
+// returning the digest of its manifest, the image id containerd reports
+func (f *fakeContainerd) addImage(t *testing.T, namespace string, labels map[string]string, names ...string) string {
+	layer := f.addBlob(t, ocispec.MediaTypeImageLayer, names)
+	config := f.addBlob(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config:       ocispec.ImageConfig{Labels: labels},
+		RootFS:       ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.Digest}},
+	})
+	manifest := f.addBlob(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Config: config,
+		Layers: []ocispec.Descriptor{layer},
+	})
+	f.addRecord(namespace, manifest, names...)
+	return manifest.Digest.String()
+}
+
+// addRecord stores image records named names targeting the descriptor in namespace
+func (f *fakeContainerd) addRecord(namespace string, target ocispec.Descriptor, names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range names {
+		f.images[namespace] = append(f.images[namespace], imagesapi.Image{
+			Name:   name,
+			Target: types.Descriptor{MediaType: target.MediaType, Digest: target.Digest, Size_: target.Size},
+		})
+	}
+}
+
+// deleteImages removes the image records of the namespace, like an image being deleted
+func (f *fakeContainerd) deleteImages(namespace string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.images, namespace)
+}
+
+// collectGarbage removes the blobs no image record refers to and no lease holds, like containerd's garbage
+// collector. The blobs of a record are its target and everything the target references.
+func (f *fakeContainerd) collectGarbage() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	referenced := map[digest.Digest]bool{}
+	var mark func(d digest.Digest)
+	mark = func(d digest.Digest) {
+		if referenced[d] {
+			return
+		}
+		referenced[d] = true
+		var manifest ocispec.Manifest
+		if json.Unmarshal(f.blobs[d], &manifest) == nil {
+			mark(manifest.Config.Digest)
+			for _, layer := range manifest.Layers {
+				mark(layer.Digest)
+			}
+		}
+	}
+	for _, records := range f.images {
+		for _, record := range records {
+			mark(record.Target.Digest)
+		}
+	}
+	for _, resources := range f.leases {
+		for id := range resources {
+			referenced[digest.Digest(id)] = true
+		}
+	}
+	for d := range f.blobs {
+		if !referenced[d] {
+			delete(f.blobs, d)
+		}
+	}
+}
+
+func (f *fakeContainerd) hasBlob(d string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blobs[digest.Digest(d)]
+	return ok
+}
+
+func (f *fakeContainerd) leaseCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.leases)
+}
+
+// fakeImages, fakeContent and fakeLeases serve the services of the fake
+type fakeImages struct {
+	imagesapi.UnimplementedImagesServer
+	f *fakeContainerd
+}
+
+type fakeContent struct {
+	contentapi.UnimplementedContentServer
+	f *fakeContainerd
+}
+
+type fakeLeases struct {
+	leasesapi.UnimplementedLeasesServer
+	f *fakeContainerd
+}
+
+func requestNamespace(ctx context.Context) (string, error) {
+	namespace, ok := namespaces.Namespace(ctx)
+	if !ok {
+		return "", errdefs.ToGRPC(fmt.Errorf("namespace is required: %w", errdefs.ErrFailedPrecondition))
+	}
+	return namespace, nil
+}
+
+func (s *fakeImages) Get(ctx context.Context, req *imagesapi.GetImageRequest) (*imagesapi.GetImageResponse, error) {
+	f := s.f
+	namespace, err := requestNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, image := range f.images[namespace] {
+		if image.Name == req.Name {
+			image := image
+			return &imagesapi.GetImageResponse{Image: &image}, nil
+		}
+	}
+	return nil, errdefs.ToGRPC(fmt.Errorf("image %q: %w", req.Name, errdefs.ErrNotFound))
+}
+
+func (s *fakeImages) List(ctx context.Context, req *imagesapi.ListImagesRequest) (*imagesapi.ListImagesResponse, error) {
+	f := s.f
+	namespace, err := requestNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &imagesapi.ListImagesResponse{Images: append([]imagesapi.Image{}, f.images[namespace]...)}, nil
+}
+
+func (s *fakeContent) Info(ctx context.Context, req *contentapi.InfoRequest) (*contentapi.InfoResponse, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.blobs[req.Digest]
+	if !ok {
+		return nil, errdefs.ToGRPC(fmt.Errorf("content %s: %w", req.Digest, errdefs.ErrNotFound))
+	}
+	return &contentapi.InfoResponse{Info: contentapi.Info{Digest: req.Digest, Size_: int64(len(data))}}, nil
+}
+
+func (s *fakeContent) Read(req *contentapi.ReadContentRequest, srv contentapi.Content_ReadServer) error {
+	f := s.f
+	f.mu.Lock()
+	data, ok := f.blobs[req.Digest]
+	f.mu.Unlock()
+	if !ok {
+		return errdefs.ToGRPC(fmt.Errorf("content %s: %w", req.Digest, errdefs.ErrNotFound))
+	}
+	if req.Offset > int64(len(data)) {
+		req.Offset = int64(len(data))
+	}
+	data = data[req.Offset:]
+	if req.Size_ > 0 && req.Size_ < int64(len(data)) {
+		data = data[:req.Size_]
+	}
+	return srv.Send(&contentapi.ReadContentResponse{Offset: req.Offset, Data: data})
+}
+
+func (s *fakeLeases) Create(ctx context.Context, req *leasesapi.CreateRequest) (*leasesapi.CreateResponse, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := req.ID
+	if id == "" {
+		f.nextID++
+		id = fmt.Sprintf("lease-%d", f.nextID)
+	}
+	f.leases[id] = map[string]bool{}
+	return &leasesapi.CreateResponse{Lease: &leasesapi.Lease{ID: id, Labels: req.Labels}}, nil
+}
+
+func (s *fakeLeases) Delete(ctx context.Context, req *leasesapi.DeleteRequest) (*ptypes.Empty, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.leases[req.ID]; !ok {
+		return nil, errdefs.ToGRPC(fmt.Errorf("lease %s: %w", req.ID, errdefs.ErrNotFound))
+	}
+	delete(f.leases, req.ID)
+	return &ptypes.Empty{}, nil
+}
+
+func (s *fakeLeases) AddResource(ctx context.Context, req *leasesapi.AddResourceRequest) (*ptypes.Empty, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resources, ok := f.leases[req.ID]
+	if !ok {
+		return nil, errdefs.ToGRPC(fmt.Errorf("lease %s: %w", req.ID, errdefs.ErrNotFound))
+	}
+	resources[req.Resource.ID] = true
+	return &ptypes.Empty{}, nil
+}
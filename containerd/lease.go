@@ -0,0 +1,78 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"context"
+	ctrd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// leaseExpiration is how long a lease pins image content when it isn't released, like after a crash
+const leaseExpiration = 24 * time.Hour
+
+// leaseImageContent pins the blobs of the image in a new lease, so containerd's garbage collector can't remove
+// them while the image is read, even when the image is deleted or repulled meanwhile. Failing to lease isn't an
+// error, it is logged and the image is read without a lease as before. The returned release deletes the lease.
+func leaseImageContent(ctx context.Context, clientd *ctrd.Client, image ctrd.Image) func() {
+	namespace, _ := namespaces.Namespace(ctx)
+	manager := clientd.LeasesService()
+	lease, err := manager.Create(ctx, leases.WithRandomID(), leases.WithExpiration(leaseExpiration),
+		leases.WithLabels(map[string]string{"io.deepfence.vessel.image": image.Name()}))
+	if err != nil {
+		logrus.Debugf("could not lease the content of image %s, reading it without a lease: %v", image.Name(), err)
+		return func() {}
+	}
+	release := func() {
+		// the lease outlives ctx, which may be done by now
+		if err := manager.Delete(namespaces.WithNamespace(context.Background(), namespace), lease); err != nil {
+			logrus.Debugf("could not release the lease %s of image %s, it expires in %s: %v", lease.ID, image.Name(), leaseExpiration, err)
+		}
+	}
+	store := image.ContentStore()
+	err = images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		err := manager.AddResource(ctx, lease, leases.Resource{ID: desc.Digest.String(), Type: "content"})
+		if err != nil {
+			return nil, err
+		}
+		children, err := images.Children(ctx, store, desc)
+		// only the manifests of the pulled platforms are in the content store
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return children, err
+	}), image.Target())
+	if err != nil {
+		logrus.Debugf("could not lease all content of image %s: %v", image.Name(), err)
+	}
+	return release
+}
+
+// leaseImage leases the content of the named image in namespace for the nerdctl commands reading it, see
+// leaseImageContent. The returned release deletes the lease and closes the client.
+func (c Containerd) leaseImage(imageName, namespace string) func() {
+	clientd, err := c.newClient()
+	if err != nil {
+		logrus.Debugf("could not lease the content of image %s, reading it without a lease: %v", imageName, err)
+		return func() {}
+	}
+	ctx := namespaces.WithNamespace(context.Background(), namespace)
+	image, err := clientd.GetImage(ctx, imageName)
+	if err != nil {
+		c.closeClient(clientd)
+		logrus.Debugf("could not lease the content of image %s, reading it without a lease: %v", imageName, err)
+		return func() {}
+	}
+	release := leaseImageContent(ctx, clientd, image)
+	return func() {
+		release()
+		c.closeClient(clientd)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"encoding/json"
+	ctrd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"testing"
+	"time"
+)
+
+// imageBlobs returns the digests of the manifest, config and layers of the image the fake stores
+func imageBlobs(t *testing.T, fake *fakeContainerd, manifestDigest string) []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(fake.blobs[digest.Digest(manifestDigest)], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	blobs := []string{manifestDigest, manifest.Config.Digest.String()}
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, layer.Digest.String())
+	}
+	return blobs
+}
+
+func TestLeaseImageSurvivesConcurrentGC(t *testing.T) {
+	fake := newFakeContainerd(t)
+	manifest := fake.addImage(t, "k8s.io", nil, "docker.io/library/nginx:latest")
+	blobs := imageBlobs(t, fake, manifest)
+
+	release := fake.runtime("").leaseImage("docker.io/library/nginx:latest", "k8s.io")
+	// the image is deleted and the garbage collector runs while the image is being read
+	fake.deleteImages("k8s.io")
+	stop := make(chan struct{})
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				fake.collectGarbage()
+			}
+		}
+	}()
+	clientd, err := ctrd.NewWithConn(fake.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := namespaceContext("k8s.io")
+	store := clientd.ContentStore()
+	for i := 0; i < 20; i++ {
+		for _, blob := range blobs {
+			info, err := store.Info(ctx, digest.Digest(blob))
+			if err == nil {
+				_, err = content.ReadBlob(ctx, store, ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+			}
+			if err != nil {
+				t.Fatalf("reading blob %s during garbage collection: %v", blob, err)
+			}
+		}
+	}
+	close(stop)
+	<-gcDone
+
+	release()
+	if fake.leaseCount() != 0 {
+		t.Errorf("release() left %d leases behind", fake.leaseCount())
+	}
+	fake.collectGarbage()
+	for _, blob := range blobs {
+		if fake.hasBlob(blob) {
+			t.Errorf("blob %s is still held after the lease was released", blob)
+		}
+	}
+}
+
+func TestLeaseImageWithoutImage(t *testing.T) {
+	fake := newFakeContainerd(t)
+	// failing to lease isn't an error, the image is read without a lease
+	release := fake.runtime("").leaseImage("docker.io/library/missing:latest", "k8s.io")
+	release()
+	if fake.leaseCount() != 0 {
+		t.Errorf("leaseImage() of a missing image left %d leases behind", fake.leaseCount())
+	}
+}
@@ -0,0 +1,347 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// criLogRoot is where the kubelet keeps the log files of pod containers
+const criLogRoot = "/var/log/pods"
+
+// criLogPollInterval is how often a followed log file is checked for new lines
+const criLogPollInterval = 250 * time.Millisecond
+
+// nerdctlLoggingParam is the query parameter of nerdctl's logging binary uri set to nerdctl's data store
+const nerdctlLoggingParam = "_NERDCTL_INTERNAL_LOGGING"
+
+// GetContainerLogs returns the stdout and stderr of the container, interleaved as written.
+// containerd doesn't store container logs itself, the io of a task is up to its creator. The logs of kubernetes
+// pod containers are read from the files the CRI plugin writes under /var/log/pods. For other containers the io
+// of the container's task is used: the json log file of nerdctl's logging or the file of a ctr --log-uri
+// file:// task. Tasks writing to fifos return ErrLogsNotSupported, reading the fifos would take the output
+// away from the task's own reader.
+func (c Containerd) GetContainerLogs(containerID, namespace string, opts models.LogOptions) (io.ReadCloser, error) {
+	clientd, err := c.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeClient(clientd)
+
+	namespace = c.namespaceOr(namespace)
+	ctx := namespaceContext(namespace)
+	container, err := clientd.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var source logSource
+	if isCRIContainer(labels) {
+		logPath, err := criLogPath(labels)
+		if err != nil {
+			return nil, fmt.Errorf("logs of container %s are not available: %v", containerID, err)
+		}
+		source = logSource{path: logPath, parse: parseCRILogLine}
+	} else {
+		task, err := clientd.TaskService().Get(ctx, &tasks.GetRequest{ContainerID: containerID})
+		if err != nil {
+			return nil, fmt.Errorf("logs of container %s are not available: %v", containerID, err)
+		}
+		source, err = taskLogSource(task.Process.Stdout, task.Process.Stderr, namespace, containerID)
+		if err != nil {
+			return nil, fmt.Errorf("logs of container %s are not available: %w", containerID, err)
+		}
+	}
+	return source.open(opts)
+}
+
+// logSource is where the logs of a container are read from, a log file whose lines are parsed with parse
+type logSource struct {
+	path  string
+	parse func(line string, since time.Time) (string, bool)
+}
+
+// open starts copying the logs of the source to the returned stream
+func (s logSource) open(opts models.LogOptions) (io.ReadCloser, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	logs := &logReader{PipeReader: pipeReader, done: make(chan struct{})}
+	go func() {
+		follower := &logFollower{path: s.path, file: file, reader: bufio.NewReader(file), parse: s.parse}
+		defer follower.Close()
+		pipeWriter.CloseWithError(copyLog(pipeWriter, follower, opts, logs.done))
+	}()
+	return logs, nil
+}
+
+// logReader is the read end of a log stream, closing it stops following the log file
+type logReader struct {
+	*io.PipeReader
+	done chan struct{}
+	once sync.Once
+}
+
+func (r *logReader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	return r.PipeReader.Close()
+}
+
+// isCRIContainer reports whether the container is a kubernetes pod container from its kubelet labels
+func isCRIContainer(labels map[string]string) bool {
+	_, ok := labels["io.kubernetes.pod.uid"]
+	return ok
+}
+
+// taskLogSource returns the log source of a container which isn't managed by the CRI plugin from the stdout and
+// stderr of its task, as set by its creator
+func taskLogSource(stdout, stderr, namespace, containerID string) (logSource, error) {
+	if stdout == "" && stderr == "" {
+		return logSource{}, fmt.Errorf("the task of the container has no io")
+	}
+	u, err := url.Parse(stdout)
+	if err != nil {
+		return logSource{}, err
+	}
+	switch u.Scheme {
+	case "file":
+		// ctr run --log-uri file:// writes stdout and stderr as is
+		return logSource{path: u.Path, parse: parseRawLogLine}, nil
+	case "binary":
+		// nerdctl's logging binary keeps json-file logs in its data store
+		dataStore := u.Query().Get(nerdctlLoggingParam)
+		if dataStore == "" {
+			return logSource{}, fmt.Errorf("logging binary %s doesn't keep log files", u.Path)
+		}
+		logPath := filepath.Join(dataStore, "containers", namespace, containerID, containerID+"-json.log")
+		return logSource{path: logPath, parse: parseJSONLogLine}, nil
+	case "":
+		// the fifos of the task are read by its creator, ctr, the shim or a logger, reading them too would
+		// take bytes away from it
+		return logSource{}, fmt.Errorf("the task writes to fifos: %w", ErrLogsNotSupported)
+	}
+	return logSource{}, fmt.Errorf("unsupported task io %s", stdout)
+}
+
+// criLogPath returns the current log file of a pod container from its kubelet labels,
+// /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart count>.log
+func criLogPath(labels map[string]string) (string, error) {
+	podNamespace := labels["io.kubernetes.pod.namespace"]
+	podName := labels["io.kubernetes.pod.name"]
+	podUID := labels["io.kubernetes.pod.uid"]
+	containerName := labels["io.kubernetes.container.name"]
+	if podNamespace == "" || podName == "" || podUID == "" || containerName == "" {
+		return "", fmt.Errorf("not a kubernetes pod container, containerd only has the logs written by the CRI plugin")
+	}
+	logDir := filepath.Join(criLogRoot, podNamespace+"_"+podName+"_"+podUID, containerName)
+	entries, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return "", err
+	}
+	var restarts []int
+	for _, entry := range entries {
+		if restart, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".log")); err == nil && strings.HasSuffix(entry.Name(), ".log") {
+			restarts = append(restarts, restart)
+		}
+	}
+	if len(restarts) == 0 {
+		return "", fmt.Errorf("no log file in %s", logDir)
+	}
+	sort.Ints(restarts)
+	return filepath.Join(logDir, strconv.Itoa(restarts[len(restarts)-1])+".log"), nil
+}
+
+// logFollower reads the lines of a log file, reopening the file at path when it is rotated or truncated
+type logFollower struct {
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+	// offset is how far file was read
+	offset int64
+	parse  func(line string, since time.Time) (string, bool)
+	// pending is the start of a line not fully written to the file yet
+	pending string
+}
+
+// readLines passes the messages of the complete lines up to the end of the log to handle,
+// the start of an incomplete last line is kept in pending
+func (l *logFollower) readLines(since time.Time, handle func(message string) error) error {
+	for {
+		chunk, err := l.reader.ReadString('\n')
+		l.offset += int64(len(chunk))
+		l.pending += chunk
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		message, ok := l.parse(strings.TrimSuffix(l.pending, "\n"), since)
+		l.pending = ""
+		if !ok {
+			continue
+		}
+		if err = handle(message); err != nil {
+			return err
+		}
+	}
+}
+
+// reopen switches to the file now at path once the followed file was rotated, renamed away for a new file to
+// be created at path, and starts over a truncated file. It's a no-op while no file is at path, between the
+// rename and the creation of the new file.
+func (l *logFollower) reopen() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return nil
+	}
+	current, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, current) {
+		if info.Size() >= l.offset {
+			return nil
+		}
+		if _, err = l.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	} else {
+		file, err := os.Open(l.path)
+		if err != nil {
+			return err
+		}
+		l.file.Close()
+		l.file = file
+	}
+	l.reader.Reset(l.file)
+	l.offset = 0
+	l.pending = ""
+	return nil
+}
+
+// Close closes the followed file
+func (l *logFollower) Close() error {
+	return l.file.Close()
+}
+
+// copyLog writes the messages of a log file to w. When following, the file is polled for new lines until done
+// is closed, the rest of a rotated file is read before the new file at its path.
+func copyLog(w io.Writer, l *logFollower, opts models.LogOptions, done <-chan struct{}) error {
+	var tail []string
+	err := l.readLines(opts.Since, func(message string) error {
+		if opts.Tail <= 0 {
+			_, err := io.WriteString(w, message)
+			return err
+		}
+		tail = append(tail, message)
+		if len(tail) > opts.Tail {
+			tail = tail[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, message := range tail {
+		if _, err := io.WriteString(w, message); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		if message, ok := l.parse(l.pending, opts.Since); ok && l.pending != "" {
+			_, err = io.WriteString(w, message)
+		}
+		return err
+	}
+
+	write := func(message string) error {
+		_, err := io.WriteString(w, message)
+		return err
+	}
+	ticker := time.NewTicker(criLogPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+		if err = l.readLines(opts.Since, write); err != nil {
+			return err
+		}
+		if err = l.reopen(); err != nil {
+			return err
+		}
+		if err = l.readLines(opts.Since, write); err != nil {
+			return err
+		}
+	}
+}
+
+// parseCRILogLine returns the message of a CRI log line written since the given time,
+// with its newline unless the line is partial
+func parseCRILogLine(line string, since time.Time) (string, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return "", false
+	}
+	if !since.IsZero() {
+		timestamp, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil || timestamp.Before(since) {
+			return "", false
+		}
+	}
+	var message string
+	if len(fields) == 4 {
+		message = fields[3]
+	}
+	if fields[2] == "P" {
+		return message, true
+	}
+	return message + "\n", true
+}
+
+// parseRawLogLine returns a line of a log file holding the output of the container as is,
+// which has no timestamps to select the lines written since a time on
+func parseRawLogLine(line string, _ time.Time) (string, bool) {
+	return line + "\n", true
+}
+
+// parseJSONLogLine returns the message of a line of a json-file log, as written by nerdctl and docker,
+// when it was written since the given time
+func parseJSONLogLine(line string, since time.Time) (string, bool) {
+	var entry struct {
+		Log  string    `json:"log"`
+		Time time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return "", false
+	}
+	if !since.IsZero() && entry.Time.Before(since) {
+		return "", false
+	}
+	return entry.Log, true
+}
@@ -0,0 +1,44 @@
+//go:build !no_containerd && linux
+// +build !no_containerd,linux
+
+package containerd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFIFOLogsNotSupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-stdout")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	// the task's own reader, like ctr or the shim
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	fifo, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fifo.Close()
+	io.WriteString(fifo, "hello\n")
+
+	if _, err = taskLogSource(path, "", "default", "app"); !errors.Is(err, ErrLogsNotSupported) {
+		t.Fatalf("taskLogSource() error = %v, want ErrLogsNotSupported", err)
+	}
+	got := make([]byte, 16)
+	n, err := reader.Read(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[:n]) != "hello\n" {
+		t.Errorf("task reader got %q, want the output left for it", got[:n])
+	}
+}
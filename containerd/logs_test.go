@@ -0,0 +1,143 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"bufio"
+	"github.com/deepfence/vessel/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTaskLogSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		stderr   string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "ctr log file",
+			stdout:   "file:///var/log/app.log",
+			stderr:   "file:///var/log/app.log",
+			wantPath: "/var/log/app.log",
+		},
+		{
+			name:     "nerdctl logging",
+			stdout:   "binary:///usr/local/bin/nerdctl?" + nerdctlLoggingParam + "=/var/lib/nerdctl/1935db59",
+			wantPath: "/var/lib/nerdctl/1935db59/containers/default/app/app-json.log",
+		},
+		{
+			name:    "other logging binary",
+			stdout:  "binary:///usr/local/bin/logger",
+			wantErr: true,
+		},
+		{
+			name:    "fifos",
+			stdout:  "/run/containerd/fifo/1/app-stdout",
+			stderr:  "/run/containerd/fifo/1/app-stderr",
+			wantErr: true,
+		},
+		{
+			name:    "no io",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := taskLogSource(tt.stdout, tt.stderr, "default", "app")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("taskLogSource() = %+v, want an error", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("taskLogSource() error = %v", err)
+			}
+			if source.path != tt.wantPath {
+				t.Errorf("path = %q, want %q", source.path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestJSONLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-json.log")
+	data := `{"log":"old\n","stream":"stdout","time":"2021-03-01T10:00:00Z"}
+{"log":"new\n","stream":"stderr","time":"2021-03-01T12:00:00Z"}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2021, 3, 1, 11, 0, 0, 0, time.UTC)
+	logs, err := logSource{path: path, parse: parseJSONLogLine}.open(models.LogOptions{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	got, err := ioutil.ReadAll(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new\n" {
+		t.Errorf("logs = %q, want %q", got, "new\n")
+	}
+}
+
+// readLine reads a line of the followed logs, failing the test when none arrives in time
+func readLine(t *testing.T, lines *bufio.Reader) string {
+	read := make(chan string, 1)
+	go func() {
+		line, _ := lines.ReadString('\n')
+		read <- line
+	}()
+	select {
+	case line := <-read:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no log line followed")
+		return ""
+	}
+}
+
+func TestFollowReopensRotatedLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := logSource{path: path, parse: parseRawLogLine}.open(models.LogOptions{Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	lines := bufio.NewReader(logs)
+	if line := readLine(t, lines); line != "first\n" {
+		t.Fatalf("line = %q, want first", line)
+	}
+
+	// rotated like by logrotate or the kubelet: renamed away and a new file created at path
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("rotated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if line := readLine(t, lines); line != "rotated\n" {
+		t.Fatalf("line after rotation = %q, want rotated", line)
+	}
+
+	// truncated in place like by logrotate's copytruncate
+	if err = ioutil.WriteFile(path, []byte("t\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if line := readLine(t, lines); line != "t\n" {
+		t.Fatalf("line after truncation = %q, want t", line)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !no_containerd && linux
+// +build !no_containerd,linux
+
+package containerd
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// lockMount takes the lock of a mount directory's lock file, held until the file is closed
+func lockMount(lock *os.File) error {
+	return unix.Flock(int(lock.Fd()), unix.LOCK_EX)
+}
+
+// tryLockMount takes the lock of a mount directory's lock file unless another process holds it,
+// reporting whether the lock was taken
+func tryLockMount(lock *os.File) (bool, error) {
+	err := unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
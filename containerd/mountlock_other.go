@@ -0,0 +1,22 @@
+//go:build !no_containerd && !linux
+// +build !no_containerd,!linux
+
+package containerd
+
+import (
+	"errors"
+	"os"
+)
+
+// errMountLockNotSupported is returned on platforms where snapshots aren't mounted, only linux flocks mount directories
+var errMountLockNotSupported = errors.New("snapshot mounts are only supported on linux")
+
+// lockMount fails, snapshots are only mounted on linux
+func lockMount(*os.File) error {
+	return errMountLockNotSupported
+}
+
+// tryLockMount fails, snapshots are only mounted on linux
+func tryLockMount(*os.File) (bool, error) {
+	return false, errMountLockNotSupported
+}
@@ -0,0 +1,202 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/mount"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountRoot is the directory snapshots are mounted under. Each mount directory <purpose>-<random> has a lock file
+// <purpose>-<random>.lock next to it, flocked by the process owning the mount for as long as it is mounted. The
+// kernel drops the lock when the process dies, so the mounts of a crashed process can be told apart from live ones
+// whatever became of its pid.
+func mountRoot() string {
+	return filepath.Join(os.TempDir(), "vessel-mounts")
+}
+
+// lockSuffix is the suffix of the lock files of the mount directories
+const lockSuffix = ".lock"
+
+// mountDir is a directory of mountRoot whose lock is held by the current process
+type mountDir struct {
+	path string
+	lock *os.File
+}
+
+// newMountDir creates a mount directory under mountRoot, locked until it is removed
+func newMountDir(purpose string) (*mountDir, error) {
+	if err := os.MkdirAll(mountRoot(), 0700); err != nil {
+		return nil, err
+	}
+	for {
+		lock, err := ioutil.TempFile(mountRoot(), purpose+"-*"+lockSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if err = lockMount(lock); err != nil {
+			lock.Close()
+			os.Remove(lock.Name())
+			return nil, err
+		}
+		// a CleanupStaleMounts running between creating and locking the file takes it for stale and removes it
+		if !lockFileCurrent(lock) {
+			lock.Close()
+			continue
+		}
+		dir := &mountDir{path: strings.TrimSuffix(lock.Name(), lockSuffix), lock: lock}
+		if err = os.Mkdir(dir.path, 0700); err != nil {
+			dir.release()
+			return nil, err
+		}
+		return dir, nil
+	}
+}
+
+// lockFileCurrent reports whether the locked file is still the one at its path
+func lockFileCurrent(lock *os.File) bool {
+	locked, err := lock.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(lock.Name())
+	return err == nil && os.SameFile(locked, current)
+}
+
+// remove removes the unmounted directory and releases its lock
+func (d *mountDir) remove() error {
+	if err := os.Remove(d.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	d.release()
+	return nil
+}
+
+// release removes the lock file and drops the lock, the lock file goes first so a directory never
+// exists with an unlocked lock file of a live mount
+func (d *mountDir) release() {
+	os.Remove(d.lock.Name())
+	d.lock.Close()
+}
+
+// mountReadOnly mounts the snapshot mounts read-only under a new directory of mountRoot, returning the
+// mount path and an unmount which removes the directory. On failure nothing is left mounted.
+func mountReadOnly(mounts []mount.Mount, purpose string) (string, func() error, error) {
+	dir, err := newMountDir(purpose)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = mount.All(readOnlyMounts(mounts), dir.path); err != nil {
+		// mount.All may have mounted some of the mounts before failing
+		mount.UnmountAll(dir.path, 0)
+		dir.remove()
+		return "", nil, fmt.Errorf("failed to mount snapshot: %v", err)
+	}
+	unmount := func() error {
+		if err := mount.UnmountAll(dir.path, 0); err != nil {
+			return err
+		}
+		return dir.remove()
+	}
+	return dir.path, unmount, nil
+}
+
+// withReadOnlyMount calls fn with the snapshot mounted read-only, unmounting it once fn returns
+func withReadOnlyMount(mounts []mount.Mount, purpose string, fn func(root string) error) (err error) {
+	root, unmount, err := mountReadOnly(mounts, purpose)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unmountErr := unmount(); unmountErr != nil && err == nil {
+			err = unmountErr
+		}
+	}()
+	return fn(root)
+}
+
+// CleanupStaleMounts unmounts and removes the snapshot mounts left behind by vessel processes which
+// are no longer running, like an agent killed during an extraction. Agents should call it at startup.
+// A mount is stale when no process holds the lock of its directory, the mounts of live processes,
+// this one included, are left alone.
+func CleanupStaleMounts() error {
+	entries, err := ioutil.ReadDir(mountRoot())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cleanupErrs []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(mountRoot(), name+lockSuffix)); err == nil {
+				// cleaned up along with its lock file
+				continue
+			}
+			// live mounts always have a lock file, a directory without one was left by a crash while removing it
+			if err = unmountStale(filepath.Join(mountRoot(), name)); err != nil {
+				cleanupErrs = append(cleanupErrs, err.Error())
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, lockSuffix) {
+			continue
+		}
+		if err = cleanupLockedMount(filepath.Join(mountRoot(), name)); err != nil {
+			cleanupErrs = append(cleanupErrs, err.Error())
+		}
+	}
+	if len(cleanupErrs) > 0 {
+		return fmt.Errorf("failed to clean up stale mounts: %s", strings.Join(cleanupErrs, ", "))
+	}
+	return nil
+}
+
+// cleanupLockedMount removes the mount directory of the lock file unless its owner still holds the lock
+func cleanupLockedMount(lockPath string) error {
+	lock, err := os.Open(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", lockPath, err)
+	}
+	defer lock.Close()
+	locked, err := tryLockMount(lock)
+	if err != nil {
+		return fmt.Errorf("%s: %v", lockPath, err)
+	}
+	if !locked {
+		// the mount is live
+		return nil
+	}
+	// the owner may have removed the mount and its lock file since the directory was read
+	if !lockFileCurrent(lock) {
+		return nil
+	}
+	if err = unmountStale(strings.TrimSuffix(lockPath, lockSuffix)); err != nil {
+		return err
+	}
+	if err = os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %v", lockPath, err)
+	}
+	return nil
+}
+
+// unmountStale unmounts and removes a stale mount directory
+func unmountStale(target string) error {
+	if err := mount.UnmountAll(target, 0); err != nil {
+		return fmt.Errorf("%s: %v", target, err)
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %v", target, err)
+	}
+	return nil
+}
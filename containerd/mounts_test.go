@@ -0,0 +1,115 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// useMountRoot points mountRoot at a new temp dir for the test and the processes it starts
+func useMountRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		// unmounting, even a directory which isn't a mount point, needs root
+		t.Skip("cleaning up mounts needs root")
+	}
+	tmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", t.TempDir())
+	t.Cleanup(func() { os.Setenv("TMPDIR", tmpDir) })
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// TestMountDirHelper is run as a separate process by TestCleanupStaleMountsInterrupted, it creates a mount
+// directory and blocks like an extraction in progress until it is killed
+func TestMountDirHelper(t *testing.T) {
+	if os.Getenv("VESSEL_MOUNT_HELPER") == "" {
+		t.Skip("helper process")
+	}
+	dir, err := newMountDir("extract")
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Stdout.WriteString(dir.path + "\n")
+	select {}
+}
+
+func TestCleanupStaleMountsInterrupted(t *testing.T) {
+	useMountRoot(t)
+	helper := exec.Command(os.Args[0], "-test.run=^TestMountDirHelper$")
+	helper.Env = append(os.Environ(), "VESSEL_MOUNT_HELPER=1")
+	stdout, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = helper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	target, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		helper.Process.Kill()
+		t.Fatalf("helper didn't create a mount directory: %v", err)
+	}
+	target = target[:len(target)-1]
+
+	// the extraction is still running, its mount isn't stale
+	if err = CleanupStaleMounts(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(target) {
+		t.Fatal("CleanupStaleMounts() removed the mount of a running process")
+	}
+
+	// the process is killed mid extraction, without removing its mount
+	helper.Process.Kill()
+	helper.Wait()
+	if err = CleanupStaleMounts(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(target) || exists(target+lockSuffix) {
+		t.Errorf("CleanupStaleMounts() left the mount of the killed process behind")
+	}
+}
+
+func TestCleanupStaleMountsKeepsOwnMounts(t *testing.T) {
+	useMountRoot(t)
+	dir, err := newMountDir("rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CleanupStaleMounts(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(dir.path) {
+		t.Fatal("CleanupStaleMounts() removed a live mount of the current process")
+	}
+	if err = dir.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(dir.path) || exists(dir.path+lockSuffix) {
+		t.Error("remove() left the mount directory or its lock file behind")
+	}
+}
+
+func TestCleanupStaleMountsWithoutLockFile(t *testing.T) {
+	useMountRoot(t)
+	// a process which crashed between removing its directory and its lock file, or a pid named mount of
+	// an older vessel whose pid has since been reused
+	target := filepath.Join(mountRoot(), "1-rootfs-123")
+	if err := os.MkdirAll(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := CleanupStaleMounts(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(target) {
+		t.Error("CleanupStaleMounts() left a mount directory without a lock file behind")
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the USER_HZ /proc/<pid>/stat times are counted in, 100 on every linux architecture in use
+const clockTicks = 100
+
+// processStartTime returns when the process started, from its start time since boot in /proc/<pid>/stat
+func processStartTime(pid uint32) (time.Time, error) {
+	if pid == 0 {
+		return time.Time{}, fmt.Errorf("no process")
+	}
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the command name in parentheses may contain spaces, the fields after it are space separated
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	// starttime is field 22 of stat, the 20th after the command name
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	startTicks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	bootTime, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return bootTime.Add(time.Duration(startTicks) * time.Second / clockTicks), nil
+}
+
+// bootTime returns when the host booted, from the btime line of /proc/stat
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
@@ -0,0 +1,65 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"io/ioutil"
+	"net/http"
+)
+
+// newResolver creates a registry resolver honouring the TLS settings of the pull options
+func newResolver(opts models.PullOptions) (remotes.Resolver, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipTLSVerify,
+	}
+	if opts.RegistryCAFile != "" {
+		caBundle, err := ioutil.ReadFile(opts.RegistryCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry CA file: %v", err)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in registry CA file %s", opts.RegistryCAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	registryOpts := []docker.RegistryOpt{
+		docker.WithClient(httpClient),
+		docker.WithAuthorizer(docker.NewDockerAuthorizer(docker.WithAuthClient(httpClient), docker.WithAuthCreds(
+			func(host string) (string, string, error) {
+				auth, err := utils.RegistryAuth(opts.Auth, host)
+				if err != nil {
+					return "", "", err
+				}
+				// an empty username makes the secret an identity token
+				if auth.IdentityToken != "" {
+					return "", auth.IdentityToken, nil
+				}
+				return auth.Username, auth.Password, nil
+			}))),
+	}
+	if opts.PlainHTTP {
+		registryOpts = append(registryOpts, docker.WithPlainHTTP(docker.MatchAllHosts))
+	}
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(registryOpts...),
+	}), nil
+}
@@ -0,0 +1,33 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package containerd
+
+import (
+	ctrd "github.com/containerd/containerd"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// supportedSnapshotters are the snapshotters whose mounts can be mounted a second time read-only while the
+// container runs: overlay lowerdirs, bind mounts of native directories and btrfs and zfs subvolumes. The block
+// devices of devmapper can't be mounted twice, and remote snapshotters like stargz mount through fuse.
+var supportedSnapshotters = map[string]bool{
+	"overlayfs": true,
+	"native":    true,
+	"btrfs":     true,
+	"zfs":       true,
+}
+
+// checkSnapshotter returns ErrUnsupportedSnapshotter for snapshotters whose mounts vessel can't mount read-only,
+// so a scan fails instead of reading an empty or partial tree
+func checkSnapshotter(containerID, snapshotter string) error {
+	if snapshotter == "" {
+		snapshotter = ctrd.DefaultSnapshotter
+	}
+	logrus.Debugf("container %s uses the %s snapshotter", containerID, snapshotter)
+	if !supportedSnapshotters[snapshotter] {
+		return errors.Wrapf(ErrUnsupportedSnapshotter, "snapshotter %s of container %s", snapshotter, containerID)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+//go:build no_containerd
+// +build no_containerd
+
+package containerd
+
+import (
+	"context"
+	"errors"
+	"github.com/deepfence/vessel/models"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc"
+	"io"
+)
+
+// errNotCompiled is returned by every method of the Containerd runtime in builds with the no_containerd tag,
+// which leave out the containerd client for slim docker-only binaries
+var errNotCompiled = errors.New("containerd support is not compiled in, vessel was built with the no_containerd tag")
+
+// New instantiates a new Containerd runtime object
+func New() *Containerd {
+	return &Containerd{
+		socketPath: "unix:///run/containerd/containerd.sock",
+	}
+}
+
+// NewWithSocket instantiates a new Containerd runtime object using the given socket
+func NewWithSocket(socketPath string) *Containerd {
+	return &Containerd{
+		socketPath: socketPath,
+	}
+}
+
+// NewWithNamespace instantiates a new Containerd runtime object using the given socket and namespace
+func NewWithNamespace(socketPath, namespace string) *Containerd {
+	return &Containerd{
+		socketPath: socketPath,
+		namespace:  namespace,
+	}
+}
+
+// NewWithSnapshotNamespace instantiates a new Containerd runtime object using the given socket and namespace,
+// looking up container snapshots in snapshotNamespace
+func NewWithSnapshotNamespace(socketPath, namespace, snapshotNamespace string) *Containerd {
+	return &Containerd{
+		socketPath:        socketPath,
+		namespace:         namespace,
+		snapshotNamespace: snapshotNamespace,
+	}
+}
+
+// NewWithConn instantiates a new Containerd runtime object over an existing grpc connection to containerd
+func NewWithConn(conn *grpc.ClientConn, namespace string) *Containerd {
+	return &Containerd{
+		conn:      conn,
+		namespace: namespace,
+	}
+}
+
+// GetSocket is socket getter
+func (c Containerd) GetSocket() string {
+	return c.socketPath
+}
+
+func (c Containerd) ExtractImage(imageID, imageName, path string) error {
+	return errNotCompiled
+}
+
+func (c Containerd) ExtractImageWithProgress(imageID, imageName, path string, progress models.ProgressFunc) error {
+	return errNotCompiled
+}
+
+func (c Containerd) ExtractImageWithOptions(imageID, imageName, path string, opts models.ExtractOptions) error {
+	return errNotCompiled
+}
+
+func (c Containerd) ExtractFlattenedFS(imageName, outputTarPath string) error {
+	return errNotCompiled
+}
+
+func (c Containerd) ExtractFlattenedFSWithOptions(imageName, outputTarPath string, opts models.ExtractOptions) error {
+	return errNotCompiled
+}
+
+func (c Containerd) OpenLayer(imageName, layerDigest string) (models.LayerReader, int64, error) {
+	return nil, 0, errNotCompiled
+}
+
+func (c Containerd) ExtractImageStream(ctx context.Context, imageName, namespace string) (<-chan models.LayerResult, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetImageID(imageName string) ([]byte, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetImageIDs(names []string) (map[string]string, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) ListImages(namespace string, opts models.ListImagesOptions) ([]models.ImageInfo, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) Save(imageName, outputParam string) ([]byte, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) SaveCompressed(imageName, outputPath string, compression models.Compression) (string, error) {
+	return "", errNotCompiled
+}
+
+func (c Containerd) GetContainerDiff(containerID, namespace string) ([]models.ChangedFile, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) PullImage(imageRef, namespace string, opts models.PullOptions) error {
+	return errNotCompiled
+}
+
+func (c Containerd) SubscribeContainerEvents(ctx context.Context, namespace string) (<-chan models.Event, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) PauseContainer(containerID, namespace string) error {
+	return errNotCompiled
+}
+
+func (c Containerd) UnpauseContainer(containerID, namespace string) error {
+	return errNotCompiled
+}
+
+func (c Containerd) GetContainerRootfsPath(containerID, namespace string) (string, func() error, error) {
+	return "", nil, errNotCompiled
+}
+
+func (c Containerd) GetImageRootfsPath(imageRef, namespace string) (string, func() error, error) {
+	return "", nil, errNotCompiled
+}
+
+func (c Containerd) ExportImageFilesystem(imageRef, namespace string, w io.Writer) error {
+	return errNotCompiled
+}
+
+func (c Containerd) GetContainerStatus(containerID, namespace string) (string, error) {
+	return "", errNotCompiled
+}
+
+func (c Containerd) GetContainerPID(containerID, namespace string) (int, error) {
+	return 0, errNotCompiled
+}
+
+func (c Containerd) GetContainerMounts(containerID, namespace string) ([]models.Mount, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetContainerSpec(containerID, namespace string) (*specs.Spec, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) CheckpointContainer(containerID, namespace, outDir string) error {
+	return errNotCompiled
+}
+
+func (c Containerd) ListContainerIDs(namespace string) ([]string, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) ListContainers(namespace string, opts models.ListContainersOptions) ([]string, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetImageDigest(imageRef, namespace string) (string, error) {
+	return "", errNotCompiled
+}
+
+func (c Containerd) GetImageLabels(imageName string) (map[string]string, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetImageLayers(imageRef, namespace string) ([]string, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetImageHistory(imageRef, namespace string) ([]models.HistoryEntry, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetContainerInfo(containerID, namespace string) (*models.ContainerInfo, error) {
+	return nil, errNotCompiled
+}
+
+func (c Containerd) GetContainerImage(containerID, namespace string) (models.ImageRef, error) {
+	return models.ImageRef{}, errNotCompiled
+}
+
+func (c Containerd) GetContainerLogs(containerID, namespace string, opts models.LogOptions) (io.ReadCloser, error) {
+	return nil, errNotCompiled
+}
+
+// CleanupStaleMounts has nothing to clean up without containerd support
+func CleanupStaleMounts() error {
+	return nil
+}
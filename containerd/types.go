@@ -1,5 +1,41 @@
 package containerd
 
+import (
+	"errors"
+	"github.com/containerd/continuity/fs"
+	"github.com/deepfence/vessel/models"
+	"google.golang.org/grpc"
+)
+
+// ErrUnsupportedSnapshotter is returned for container snapshots of a snapshotter vessel can't mount read-only
+var ErrUnsupportedSnapshotter = errors.New("snapshotter not supported")
+
+// ErrLogsNotSupported is returned for the logs of containers whose task io isn't kept in a log file
+var ErrLogsNotSupported = errors.New("container logs not supported")
+
 type Containerd struct {
 	socketPath string
+	// namespace is used by the methods without a namespace parameter and by the others when it's empty
+	namespace string
+	// snapshotNamespace is where the snapshots of containers are looked up, the container's namespace when empty
+	snapshotNamespace string
+	// conn is an existing connection used instead of dialing socketPath, it is owned by the caller
+	conn *grpc.ClientConn
+}
+
+// changeKinds maps the continuity change kinds to vessel change kinds
+var changeKinds = map[fs.ChangeKind]models.ChangeKind{
+	fs.ChangeKindAdd:    models.ChangeAdded,
+	fs.ChangeKindModify: models.ChangeModified,
+	fs.ChangeKindDelete: models.ChangeDeleted,
+}
+
+// eventActions maps the containerd event topics to vessel event actions
+var eventActions = map[string]string{
+	"/containers/create": "create",
+	"/tasks/start":       "start",
+	"/tasks/exit":        "die",
+	"/tasks/paused":      "pause",
+	"/tasks/resumed":     "unpause",
+	"/containers/delete": "destroy",
 }
@@ -0,0 +1,268 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/utils"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"time"
+)
+
+// containerdSupported is false in builds with the no_containerd tag, which only detect docker
+const containerdSupported = true
+
+// ConnectContainerd returns a containerd client for the daemon at host, which may be given with or
+// without its unix:// scheme. The client is set up the way vessel connects to containerd, with
+// containerd's default message size limits and a blocking dial bounded by the detection timeout.
+func ConnectContainerd(host string) (*containerd.Client, error) {
+	return ConnectContainerdWithOptions(host, Options{})
+}
+
+// ConnectContainerdWithOptions is ConnectContainerd connecting with the dialer, TLS and grpc dial options of opts
+func ConnectContainerdWithOptions(host string, opts Options) (*containerd.Client, error) {
+	ep, err := resolveEndpoint(host, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newContainerdClient(context.Background(), ep, opts.GRPCDialOptions)
+}
+
+// probeContainerd reports whether the containerd daemon at the endpoint counts as detected for the detection mode,
+// the dial and the probe calls are abandoned once ctx is done
+func probeContainerd(ctx context.Context, ep *runtimeEndpoint, detectBy DetectBy, opts Options) (bool, error) {
+	if ep.protocol == constants.HTTPProtocol || ep.protocol == constants.HTTPSProtocol || ep.protocol == constants.SSHProtocol {
+		return false, fmt.Errorf("%s endpoint '%s' is only supported for docker", ep.protocol, ep.url)
+	}
+	if detectBy == SocketReachable {
+		dialCtx, cancel := context.WithTimeout(ctx, ep.timeout)
+		conn, err := ep.dial(dialCtx, ep.addr)
+		cancel()
+		if err != nil {
+			return false, errors.Wrapf(err, "could not connect to endpoint '%s'", ep.url)
+		}
+		conn.Close()
+		return true, nil
+	}
+
+	clientd, err := newContainerdClient(ctx, ep, opts.GRPCDialOptions)
+	if err != nil {
+		return false, err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	if detectBy == DaemonResponds {
+		queryCtx, cancel := context.WithTimeout(ctx, ep.timeout)
+		defer cancel()
+		return isContainerdResponding(queryCtx, clientd)
+	}
+	if opts.DetectNamespace && ep.namespace == "" {
+		// the detected namespace is reported along with the runtime
+		ep.namespace, err = busiestNamespace(ctx, clientd, ep.timeout, opts.logger())
+		if err != nil {
+			return false, err
+		}
+	}
+	selector, err := utils.ParseLabelSelector(opts.LabelSelector)
+	if err != nil {
+		return false, err
+	}
+	return isContainerdRunning(ctx, clientd, ep.containerdNamespace(), selector, ep.timeout)
+}
+
+// dialContainerd opens the grpc connection to the containerd endpoint, extra dial options are appended to the defaults.
+// The dial is bounded by the endpoint timeout and ctx rather than grpc.WithTimeout, which newer grpc releases dropped,
+// so a caller can abandon it sooner.
+func dialContainerd(ctx context.Context, ep *runtimeEndpoint, grpcDialOpts []grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := []grpc.DialOption{
+		ep.grpcCredentials(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithContextDialer(ep.dial),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(defaults.DefaultMaxRecvMsgSize)),
+		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(defaults.DefaultMaxSendMsgSize)),
+	}
+	ctx, cancel := context.WithTimeout(ctx, ep.timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, ep.addr, append(dialOpts, grpcDialOpts...)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not connect to endpoint '%s'", ep.url)
+	}
+	return conn, nil
+}
+
+// the grpc apis the containerd dial relies on, available from grpc v1.34 through the current releases.
+// A grpc version outside that range fails to build here instead of deep inside the dial.
+var (
+	_ func(context.Context, string, ...grpc.DialOption) (*grpc.ClientConn, error) = grpc.DialContext
+	_ func() grpc.DialOption                                                      = grpc.WithBlock
+	_ func(bool) grpc.DialOption                                                  = grpc.FailOnNonTempDialError
+	_ func(func(context.Context, string) (net.Conn, error)) grpc.DialOption       = grpc.WithContextDialer
+	_ func(credentials.TransportCredentials) grpc.DialOption                      = grpc.WithTransportCredentials
+	_ func() credentials.TransportCredentials                                     = insecure.NewCredentials
+)
+
+// newContainerdClient connects a containerd client to the endpoint, over the connection of the endpoint when
+// it has one, ctx only bounds the dial. The client has to be released with releaseContainerdClient.
+func newContainerdClient(ctx context.Context, ep *runtimeEndpoint, grpcDialOpts []grpc.DialOption) (*containerd.Client, error) {
+	if ep.conn != nil {
+		clientd, err := containerd.NewWithConn(ep.conn)
+		if err != nil {
+			return nil, errors.Wrapf(err, " :error creating containerd client")
+		}
+		return clientd, nil
+	}
+	conn, err := dialContainerd(ctx, ep, grpcDialOpts)
+	if err != nil {
+		return nil, err
+	}
+	clientd, err := containerd.NewWithConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, " :error creating containerd client")
+	}
+	return clientd, nil
+}
+
+// releaseContainerdClient closes a client created by newContainerdClient, unless it runs over a connection
+// passed in with the endpoint, which is left to its owner
+func releaseContainerdClient(ep *runtimeEndpoint, clientd *containerd.Client) {
+	if ep.conn == nil {
+		clientd.Close()
+	}
+}
+
+func isContainerdResponding(ctx context.Context, clientd *containerd.Client) (bool, error) {
+	if _, err := clientd.Version(ctx); err != nil {
+		return false, errors.Wrapf(err, " :error querying containerd version")
+	}
+	return true, nil
+}
+
+// isContainerdRunning reports whether the namespace has containers, or images when there is no selector.
+// With a selector only the containers matching it count, filtered by their labels after listing. The queries
+// share the timeout, so a daemon that accepts connections but doesn't answer fails instead of hanging.
+func isContainerdRunning(ctx context.Context, clientd *containerd.Client, namespace string, selector utils.LabelSelector, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// create a context with the containerd namespace of the endpoint, k8s.io by default
+	k8s := namespaces.WithNamespace(ctx, namespace)
+
+	if len(selector) > 0 {
+		containers, err := clientd.ContainerService().List(k8s)
+		if err != nil {
+			return false, errors.Wrapf(err, " :error listing containerd containers")
+		}
+		for _, container := range containers {
+			if selector.Matches(container.Labels) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	containers, err := clientd.Containers(k8s)
+	if err != nil {
+		return false, errors.Wrapf(err, " :error creating containerd client")
+	}
+
+	if len(containers) > 0 {
+		return true, nil
+	}
+
+	// a node may have images pulled before any container is created,
+	// which is still evidence of a live containerd
+	images, err := clientd.ImageService().List(k8s)
+	if err != nil {
+		return false, errors.Wrapf(err, " :error listing containerd images")
+	}
+	if len(images) > 0 {
+		return true, nil
+	}
+	return false, nil
+}
+
+// countContainerdContainers returns the number of containers in the namespace of the containerd endpoint,
+// only counting the ones matching the selector like isContainerdRunning
+func countContainerdContainers(ep *runtimeEndpoint, selector utils.LabelSelector, opts Options) (int, error) {
+	clientd, err := newContainerdClient(context.Background(), ep, opts.GRPCDialOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+	containers, err := clientd.ContainerService().List(namespaces.WithNamespace(ctx, ep.containerdNamespace()))
+	if err != nil {
+		return 0, errors.Wrapf(err, " :error listing containerd containers")
+	}
+	count := 0
+	for _, container := range containers {
+		if selector.Matches(container.Labels) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// containerdVersion returns the version of the containerd daemon at the endpoint
+func containerdVersion(ep *runtimeEndpoint, opts Options) (string, error) {
+	clientd, err := newContainerdClient(context.Background(), ep, opts.GRPCDialOptions)
+	if err != nil {
+		return "", err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+	version, err := clientd.Version(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, " :error querying containerd version")
+	}
+	return version.Version, nil
+}
+
+// pingContainerd checks that the containerd daemon at the endpoint answers its version service,
+// which unlike listing containers needs no namespace
+func pingContainerd(ctx context.Context, ep *runtimeEndpoint, opts Options) error {
+	clientd, err := newContainerdClient(ctx, ep, opts.GRPCDialOptions)
+	if err != nil {
+		return err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	_, err = isContainerdResponding(ctx, clientd)
+	return err
+}
+
+// hasContainerdKubernetesContainers reports whether the containerd daemon at the endpoint has containers
+// in the k8s.io namespace of the kubelet
+func hasContainerdKubernetesContainers(ep *runtimeEndpoint, opts Options) (bool, error) {
+	clientd, err := newContainerdClient(context.Background(), ep, opts.GRPCDialOptions)
+	if err != nil {
+		return false, err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+	containers, err := clientd.Containers(namespaces.WithNamespace(ctx, constants.CONTAINERD_K8S_NS))
+	if err != nil {
+		return false, errors.Wrapf(err, " :error listing containerd containers")
+	}
+	return len(containers) > 0, nil
+}
+
+// grpcCredentials returns the grpc transport security for the endpoint, plaintext unless TLS is enabled
+func (ep *runtimeEndpoint) grpcCredentials() grpc.DialOption {
+	if ep.tlsConfig == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(ep.tlsConfig))
+}
@@ -0,0 +1,177 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package vessel
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/containerd/containerd"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/utils"
+	"google.golang.org/grpc"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeContainerdServer holds the container and image records of containerd namespaces, what
+// isContainerdRunning lists
+type fakeContainerdServer struct {
+	containers map[string][]containersapi.Container
+	images     map[string][]imagesapi.Image
+}
+
+// fakeContainers serves the containers of a fakeContainerdServer
+type fakeContainers struct {
+	containersapi.UnimplementedContainersServer
+	s *fakeContainerdServer
+}
+
+func (f *fakeContainers) List(ctx context.Context, _ *containersapi.ListContainersRequest) (*containersapi.ListContainersResponse, error) {
+	namespace, _ := namespaces.Namespace(ctx)
+	return &containersapi.ListContainersResponse{Containers: f.s.containers[namespace]}, nil
+}
+
+// fakeImages serves the images of a fakeContainerdServer
+type fakeImages struct {
+	imagesapi.UnimplementedImagesServer
+	s *fakeContainerdServer
+}
+
+func (f *fakeImages) List(ctx context.Context, _ *imagesapi.ListImagesRequest) (*imagesapi.ListImagesResponse, error) {
+	namespace, _ := namespaces.Namespace(ctx)
+	return &imagesapi.ListImagesResponse{Images: f.s.images[namespace]}, nil
+}
+
+// newFakeContainerdConn returns a connection to the fake server, both closed when the test ends
+func newFakeContainerdConn(t *testing.T, s *fakeContainerdServer) *grpc.ClientConn {
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	containersapi.RegisterContainersServer(server, &fakeContainers{s: s})
+	imagesapi.RegisterImagesServer(server, &fakeImages{s: s})
+	go server.Serve(listener)
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return conn
+}
+
+// newFakeContainerdClient returns a client of the fake server
+func newFakeContainerdClient(t *testing.T, s *fakeContainerdServer) *containerd.Client {
+	clientd, err := containerd.NewWithConn(newFakeContainerdConn(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientd
+}
+
+func TestIsContainerdRunning(t *testing.T) {
+	clientd := newFakeContainerdClient(t, &fakeContainerdServer{
+		containers: map[string][]containersapi.Container{
+			"k8s.io": {{ID: "app", Labels: map[string]string{"app": "web"}}},
+		},
+		images: map[string][]imagesapi.Image{
+			"k8s.io": {{Name: "docker.io/library/nginx:latest"}},
+			// images pulled before any container was created
+			"pulled": {{Name: "docker.io/library/nginx:latest"}},
+		},
+	})
+	tests := []struct {
+		name      string
+		namespace string
+		selector  string
+		want      bool
+	}{
+		{name: "containers", namespace: "k8s.io", want: true},
+		{name: "images but no containers", namespace: "pulled", want: true},
+		{name: "neither", namespace: "empty", want: false},
+		{name: "selected containers", namespace: "k8s.io", selector: "app=web", want: true},
+		{name: "no selected containers", namespace: "k8s.io", selector: "app=db", want: false},
+		// images have no containers to match the selector
+		{name: "images with a selector", namespace: "pulled", selector: "app=web", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := utils.ParseLabelSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := isContainerdRunning(context.Background(), clientd, tt.namespace, selector, 5*time.Second)
+			if err != nil {
+				t.Fatalf("isContainerdRunning() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isContainerdRunning() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountContainerdContainers(t *testing.T) {
+	conn := newFakeContainerdConn(t, &fakeContainerdServer{
+		containers: map[string][]containersapi.Container{
+			"k8s.io": {
+				{ID: "web", Labels: map[string]string{"app": "web"}},
+				{ID: "db", Labels: map[string]string{"app": "db"}},
+				{ID: "sidecar"},
+			},
+		},
+	})
+	ep := &runtimeEndpoint{timeout: 5 * time.Second, conn: conn}
+	for selector, want := range map[string]int{"": 3, "app": 2, "app=web": 1, "app=cache": 0} {
+		parsed, err := utils.ParseLabelSelector(selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := countContainerdContainers(ep, parsed, Options{})
+		if err != nil {
+			t.Fatalf("countContainerdContainers(%q) error = %v", selector, err)
+		}
+		if got != want {
+			t.Errorf("countContainerdContainers(%q) = %d, want %d", selector, got, want)
+		}
+	}
+}
+
+func TestDetectAndMarshalNamespace(t *testing.T) {
+	conn := newFakeContainerdConn(t, &fakeContainerdServer{
+		containers: map[string][]containersapi.Container{
+			"buildkit": {{ID: "builder"}},
+		},
+	})
+	d := NewDetector(WithEndpoints(Endpoint{
+		// the fake's socket, connected to through conn
+		URL:       conn.Target(),
+		Runtime:   constants.CONTAINERD,
+		Namespace: "buildkit",
+		Conn:      conn,
+	}))
+	detected, out, err := detectAndMarshal(context.Background(), d)
+	if err != nil {
+		t.Fatalf("detectAndMarshal() error = %v", err)
+	}
+	if detected.Namespace != "buildkit" {
+		t.Errorf("detected namespace = %q, want buildkit", detected.Namespace)
+	}
+	var marshaled DetectedRuntime
+	if err := json.Unmarshal(out, &marshaled); err != nil {
+		t.Fatal(err)
+	}
+	if marshaled != *detected {
+		t.Errorf("marshaled %s, want %+v", out, *detected)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build no_containerd
+// +build no_containerd
+
+package vessel
+
+import (
+	"context"
+	"github.com/deepfence/vessel/utils"
+)
+
+// containerdSupported is false in builds with the no_containerd tag, which only detect docker
+const containerdSupported = false
+
+func probeContainerd(ctx context.Context, ep *runtimeEndpoint, detectBy DetectBy, opts Options) (bool, error) {
+	return false, ErrContainerdNotCompiled
+}
+
+func countContainerdContainers(ep *runtimeEndpoint, selector utils.LabelSelector, opts Options) (int, error) {
+	return 0, ErrContainerdNotCompiled
+}
+
+func containerdVersion(ep *runtimeEndpoint, opts Options) (string, error) {
+	return "", ErrContainerdNotCompiled
+}
+
+func pingContainerd(ctx context.Context, ep *runtimeEndpoint, opts Options) error {
+	return ErrContainerdNotCompiled
+}
+
+func hasContainerdKubernetesContainers(ep *runtimeEndpoint, opts Options) (bool, error) {
+	return false, ErrContainerdNotCompiled
+}
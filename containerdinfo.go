@@ -0,0 +1,118 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package vessel
+
+import (
+	"context"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/defaults"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/plugin"
+	"github.com/deepfence/vessel/constants"
+	"github.com/pkg/errors"
+)
+
+// ContainerdInfo describes a containerd daemon, for deciding whether its setup is supported before using it
+type ContainerdInfo struct {
+	Version string `json:"version"`
+	// DefaultSnapshotter is the snapshotter used for the k8s.io namespace
+	DefaultSnapshotter string             `json:"defaultSnapshotter"`
+	Plugins            []ContainerdPlugin `json:"plugins"`
+}
+
+// ContainerdPlugin is a plugin of a containerd daemon, like the io.containerd.snapshotter.v1 overlayfs plugin
+type ContainerdPlugin struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// Loaded is false for plugins which failed to initialize, like a snapshotter unsupported by the filesystem
+	Loaded bool `json:"loaded"`
+}
+
+// Snapshotters returns the ids of the loaded snapshotter plugins
+func (i *ContainerdInfo) Snapshotters() []string {
+	var snapshotters []string
+	for _, p := range i.Plugins {
+		if p.Type == plugin.SnapshotPlugin.String() && p.Loaded {
+			snapshotters = append(snapshotters, p.ID)
+		}
+	}
+	return snapshotters
+}
+
+// GetSnapshotter returns the default snapshotter of the namespace on the containerd daemon at sockPath,
+// like overlayfs, native or stargz, the k8s.io namespace is used when namespace is empty
+func GetSnapshotter(sockPath, namespace string) (string, error) {
+	if namespace == "" {
+		namespace = constants.CONTAINERD_K8S_NS
+	}
+	ep, err := resolveEndpoint(sockPath, Options{})
+	if err != nil {
+		return "", err
+	}
+	clientd, err := newContainerdClient(context.Background(), ep, nil)
+	if err != nil {
+		return "", err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+	ctx = namespaces.WithNamespace(ctx, namespace)
+	if _, err = clientd.Version(ctx); err != nil {
+		return "", errors.Wrapf(err, " :error querying containerd version")
+	}
+	return defaultSnapshotter(ctx, clientd, namespace), nil
+}
+
+// defaultSnapshotter returns the snapshotter of the namespace the way the containerd client picks it:
+// the namespace's default snapshotter label, falling back to the platform default
+func defaultSnapshotter(ctx context.Context, clientd *containerd.Client, namespace string) string {
+	labels, err := clientd.NamespaceService().Labels(ctx, namespace)
+	if err == nil && labels[defaults.DefaultSnapshotterNSLabel] != "" {
+		return labels[defaults.DefaultSnapshotterNSLabel]
+	}
+	return containerd.DefaultSnapshotter
+}
+
+// GetContainerdInfo returns the version, default snapshotter and plugins of the containerd daemon at sockPath,
+// the plugins are listed with the introspection service
+func GetContainerdInfo(sockPath string) (*ContainerdInfo, error) {
+	ep, err := resolveEndpoint(sockPath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	clientd, err := newContainerdClient(context.Background(), ep, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseContainerdClient(ep, clientd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+	ctx = namespaces.WithNamespace(ctx, constants.CONTAINERD_K8S_NS)
+	version, err := clientd.Version(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, " :error querying containerd version")
+	}
+	info := &ContainerdInfo{
+		Version:            version.Version,
+		DefaultSnapshotter: defaultSnapshotter(ctx, clientd, constants.CONTAINERD_K8S_NS),
+	}
+
+	plugins, err := clientd.IntrospectionService().Plugins(ctx, nil)
+	if errdefs.IsNotImplemented(err) {
+		return nil, errors.Errorf("containerd introspection service is unavailable on endpoint '%s'", sockPath)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, " :error listing containerd plugins")
+	}
+	for _, p := range plugins.Plugins {
+		info.Plugins = append(info.Plugins, ContainerdPlugin{
+			Type:   p.Type,
+			ID:     p.ID,
+			Loaded: p.InitErr == nil,
+		})
+	}
+	return info, nil
+}
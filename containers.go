@@ -0,0 +1,294 @@
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/containerd"
+	"github.com/deepfence/vessel/docker"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// newContainerd returns the containerd runtime at sockPath, defaulting to the namespace vessel detects
+// containers in there, moby for docker's own containerd and k8s.io otherwise
+func newContainerd(sockPath string) *containerd.Containerd {
+	return containerd.NewWithNamespace(sockPath, socketNamespace(sockPath))
+}
+
+// SubscribeContainerEvents streams container lifecycle events from the runtime at sockPath,
+// the channel is closed when ctx is cancelled
+func SubscribeContainerEvents(ctx context.Context, runtime, sockPath, namespace string) (<-chan models.Event, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).SubscribeContainerEvents(ctx, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).SubscribeContainerEvents(ctx, namespace)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetContainerRootfsPath returns a path to the container's rootfs that can be scanned in place
+// and a cleanup to call once done. For containerd a read-only mount of the container snapshot is
+// prepared, for docker the overlay2 merged directory is returned. ErrUnsupportedSnapshotter is returned for
+// containerd snapshotters which can't be mounted read-only, like devmapper.
+func GetContainerRootfsPath(runtime, sockPath, containerID, namespace string) (string, func() error, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetContainerRootfsPath(containerID, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).GetContainerRootfsPath(containerID, namespace)
+	}
+	return "", nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetContainerRootfsPathWithSnapshotNamespace is GetContainerRootfsPath looking up the snapshot of a containerd
+// container in snapshotNamespace rather than in the namespace the container is listed in, for clusters preparing
+// snapshots under a separate service namespace. snapshotNamespace is ignored for docker.
+func GetContainerRootfsPathWithSnapshotNamespace(runtime, sockPath, containerID, namespace, snapshotNamespace string) (string, func() error, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetContainerRootfsPath(containerID, namespace)
+	case constants.CONTAINERD:
+		return containerd.NewWithSnapshotNamespace(sockPath, socketNamespace(sockPath), snapshotNamespace).GetContainerRootfsPath(containerID, namespace)
+	}
+	return "", nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetContainerStatus returns the state of the container normalized to one of running, paused,
+// exited or created. ErrContainerNotFound is returned when the container doesn't exist.
+func GetContainerStatus(runtime, sockPath, containerID, namespace string) (string, error) {
+	var status string
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		status, err = docker.NewWithSocket(sockPath).GetContainerStatus(containerID, namespace)
+	case constants.CONTAINERD:
+		status, err = newContainerd(sockPath).GetContainerStatus(containerID, namespace)
+	default:
+		return "", fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return "", containerError(containerID, err)
+	}
+	return status, nil
+}
+
+// PauseContainer freezes the processes of the running container, for a consistent snapshot of its filesystem.
+// An error is returned when the container isn't running, ErrContainerNotFound when it doesn't exist.
+func PauseContainer(runtime, sockPath, containerID, namespace string) error {
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		err = docker.NewWithSocket(sockPath).PauseContainer(containerID, namespace)
+	case constants.CONTAINERD:
+		err = newContainerd(sockPath).PauseContainer(containerID, namespace)
+	default:
+		return fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	return containerError(containerID, err)
+}
+
+// UnpauseContainer resumes the processes of a container paused with PauseContainer. An error is returned
+// when the container isn't paused, ErrContainerNotFound when it doesn't exist.
+func UnpauseContainer(runtime, sockPath, containerID, namespace string) error {
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		err = docker.NewWithSocket(sockPath).UnpauseContainer(containerID, namespace)
+	case constants.CONTAINERD:
+		err = newContainerd(sockPath).UnpauseContainer(containerID, namespace)
+	default:
+		return fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	return containerError(containerID, err)
+}
+
+// GetContainerPID returns the host pid of the container's init process, for entering its namespaces.
+// ErrContainerNotFound is returned when the container doesn't exist.
+func GetContainerPID(runtime, sockPath, containerID, namespace string) (int, error) {
+	var pid int
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		pid, err = docker.NewWithSocket(sockPath).GetContainerPID(containerID, namespace)
+	case constants.CONTAINERD:
+		pid, err = newContainerd(sockPath).GetContainerPID(containerID, namespace)
+	default:
+		return 0, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return 0, containerError(containerID, err)
+	}
+	return pid, nil
+}
+
+// GetContainerInfo returns the name, image, creation and start times and state of the container in one inspect,
+// for reporting container uptime. ErrContainerNotFound is returned when the container doesn't exist.
+func GetContainerInfo(runtime, sockPath, containerID, namespace string) (*models.ContainerInfo, error) {
+	var info *models.ContainerInfo
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		info, err = docker.NewWithSocket(sockPath).GetContainerInfo(containerID, namespace)
+	case constants.CONTAINERD:
+		info, err = newContainerd(sockPath).GetContainerInfo(containerID, namespace)
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return nil, containerError(containerID, err)
+	}
+	return info, nil
+}
+
+// GetContainerMounts returns the volumes, host binds and tmpfs mounts of the container with whether they're writable,
+// for scanning them along with its filesystem and scoring the host paths the container can write.
+// ErrContainerNotFound is returned when the container doesn't exist.
+func GetContainerMounts(runtime, sockPath, containerID, namespace string) ([]models.Mount, error) {
+	var mounts []models.Mount
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		mounts, err = docker.NewWithSocket(sockPath).GetContainerMounts(containerID, namespace)
+	case constants.CONTAINERD:
+		mounts, err = newContainerd(sockPath).GetContainerMounts(containerID, namespace)
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return nil, containerError(containerID, err)
+	}
+	return mounts, nil
+}
+
+// GetContainerSpec returns the OCI runtime spec of the container, for auditing its capabilities, seccomp profile,
+// mounts and namespaces. For docker the spec is an approximation rebuilt from inspect, see docker.GetContainerSpec.
+// ErrContainerNotFound is returned when the container doesn't exist.
+func GetContainerSpec(runtime, sockPath, containerID, namespace string) (*specs.Spec, error) {
+	var spec *specs.Spec
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		spec, err = docker.NewWithSocket(sockPath).GetContainerSpec(containerID, namespace)
+	case constants.CONTAINERD:
+		spec, err = newContainerd(sockPath).GetContainerSpec(containerID, namespace)
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return nil, containerError(containerID, err)
+	}
+	return spec, nil
+}
+
+// CheckpointContainer checkpoints the running container with CRIU into outDir, for restoring it later or
+// analysing its memory. ErrCheckpointNotSupported is returned when the node can't checkpoint containers.
+func CheckpointContainer(runtime, sockPath, containerID, namespace, outDir string) error {
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		err = docker.NewWithSocket(sockPath).CheckpointContainer(containerID, namespace, outDir)
+	case constants.CONTAINERD:
+		err = newContainerd(sockPath).CheckpointContainer(containerID, namespace, outDir)
+	default:
+		return fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return checkpointError(containerID, err)
+	}
+	return nil
+}
+
+// GetContainerLogs returns the stdout and stderr of the container, the stream has to be closed once done.
+// For containerd the logs of kubernetes pods are read from the log files of the CRI plugin and those of other
+// containers from the io of their task, like the log files of nerdctl and ctr --log-uri, see Containerd.GetContainerLogs.
+func GetContainerLogs(runtime, sockPath, containerID, namespace string, opts models.LogOptions) (io.ReadCloser, error) {
+	var logs io.ReadCloser
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		logs, err = docker.NewWithSocket(sockPath).GetContainerLogs(containerID, namespace, opts)
+	case constants.CONTAINERD:
+		logs, err = newContainerd(sockPath).GetContainerLogs(containerID, namespace, opts)
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return nil, containerError(containerID, err)
+	}
+	return logs, nil
+}
+
+// ListContainers returns the ids of the containers of the runtime matching the options, including stopped ones.
+// A malformed label selector is returned as an error.
+func ListContainers(runtime, sockPath, namespace string, opts models.ListContainersOptions) ([]string, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).ListContainers(namespace, opts)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).ListContainers(namespace, opts)
+	default:
+		return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+}
+
+// ResolveContainerID resolves a short container id to the full id of the container it is a prefix of.
+// ErrContainerNotFound is returned when no container matches and ErrAmbiguousContainerID when several do.
+func ResolveContainerID(runtime, sockPath, shortID, namespace string) (string, error) {
+	if shortID == "" {
+		return "", errors.New("container id is not set")
+	}
+	var ids []string
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		ids, err = docker.NewWithSocket(sockPath).ListContainerIDs(namespace)
+	case constants.CONTAINERD:
+		ids, err = newContainerd(sockPath).ListContainerIDs(namespace)
+	default:
+		return "", fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, id := range ids {
+		if id == shortID {
+			return id, nil
+		}
+		if strings.HasPrefix(id, shortID) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.Wrapf(ErrContainerNotFound, "container %s", shortID)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", errors.Wrapf(ErrAmbiguousContainerID, "%s matches %s", shortID, strings.Join(matches, ", "))
+	}
+}
+
+// ExportContainerRootfs writes the container's rootfs to w as a tar archive, keeping the extended attributes,
+// file capabilities, hardlinks and device nodes a security scan needs
+func ExportContainerRootfs(runtime, sockPath, containerID, namespace string, w io.Writer, opts models.TarOptions) error {
+	rootfsPath, cleanup, err := GetContainerRootfsPath(runtime, sockPath, containerID, namespace)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return utils.TarDirectory(rootfsPath, w, opts)
+}
+
+// CleanupStaleMounts removes the containerd snapshot mounts left behind by vessel processes which
+// exited without cleaning them up, agents should call it at startup
+func CleanupStaleMounts() error {
+	return containerd.CleanupStaleMounts()
+}
@@ -0,0 +1,264 @@
+package vessel
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DetectedRuntime describes a container runtime found during detection
+type DetectedRuntime struct {
+	Runtime    string `json:"runtime"`
+	SocketPath string `json:"socketPath"`
+	Namespace  string `json:"namespace,omitempty"`
+	Reachable  bool   `json:"reachable"`
+	// ContainerCount is the number of containers of the runtime matching Options.LabelSelector,
+	// only set by DetectAllRuntimes
+	ContainerCount int `json:"containerCount,omitempty"`
+}
+
+// newDetectedRuntime builds the detection report for a runtime reached on sockPath
+func newDetectedRuntime(runtime, sockPath string) DetectedRuntime {
+	detected := DetectedRuntime{
+		Runtime:    runtime,
+		SocketPath: sockPath,
+		Reachable:  true,
+	}
+	if runtime == constants.CONTAINERD {
+		detected.Namespace = socketNamespace(sockPath)
+	}
+	return detected
+}
+
+// socketNamespace returns the containerd namespace of the images and containers reached on sockPath,
+// moby for docker's own containerd and k8s.io otherwise
+func socketNamespace(sockPath string) string {
+	if strings.TrimPrefix(sockPath, constants.UnixProtocol+"://") == strings.TrimPrefix(constants.DockerContainerdSocket, constants.UnixProtocol+"://") {
+		return constants.CONTAINERD_MOBY_NS
+	}
+	return constants.CONTAINERD_K8S_NS
+}
+
+// DetectAndMarshal auto detects the underlying container runtime and returns the result as JSON
+func DetectAndMarshal() ([]byte, error) {
+	_, out, err := detectAndMarshal(context.Background(), NewDetector())
+	return out, err
+}
+
+// detectAndMarshal detects the runtime with d, returning it along with its JSON report
+func detectAndMarshal(ctx context.Context, d *Detector) (*DetectedRuntime, []byte, error) {
+	detected, err := d.Detect(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := json.Marshal(detected)
+	if err != nil {
+		return nil, nil, err
+	}
+	return detected, out, nil
+}
+
+// runtimePriority orders the runtimes returned by DetectAllRuntimes, lower comes first
+var runtimePriority = map[string]int{
+	constants.DOCKER:     0,
+	constants.CONTAINERD: 1,
+	constants.CRIO:       2,
+}
+
+// DetectAllRuntimes detects every active container runtime instead of stopping at the first one,
+// for nodes running containers under more than one runtime. Runtimes are sorted by priority,
+// docker before containerd before cri-o, and by socket path for the same runtime.
+func DetectAllRuntimes() ([]DetectedRuntime, error) {
+	return DetectAllRuntimesWithOptions(Options{})
+}
+
+// DetectAllRuntimesWithOptions detects every active container runtime like DetectAllRuntimes using the given options
+func DetectAllRuntimesWithOptions(opts Options) ([]DetectedRuntime, error) {
+	if _, err := utils.ParseLabelSelector(opts.LabelSelector); err != nil {
+		return nil, err
+	}
+	var all []DetectedRuntime
+	var permErr error
+	probed := make(map[string]bool)
+	// the endpoints with their socket symlinks resolved, like /var/run/docker.sock and /run/docker.sock
+	probedAddrs := make(map[string]bool)
+	for _, endPoints := range candidateEndpoints(opts) {
+		for _, candidate := range endPoints {
+			endPoint, runtime := candidate.URL, candidate.Runtime
+			if probed[endPoint] {
+				continue
+			}
+			probed[endPoint] = true
+			if !containerdSupported && runtime != constants.DOCKER {
+				continue
+			}
+			ep, err := candidate.resolve(opts)
+			if err != nil {
+				opts.logger().Warn(err)
+				continue
+			}
+			addr := runtime + " " + ep.protocol + "://" + ep.addr + " " + ep.containerdNamespace()
+			if probedAddrs[addr] {
+				continue
+			}
+			probedAddrs[addr] = true
+			detected, err := probeEndpoint(context.Background(), ep, runtime, opts)
+			if err != nil {
+				if errors.Is(err, ErrPermissionDenied) {
+					permErr = err
+				}
+				logProbeError(opts.logger(), err)
+				opts.reportProbe(endPoint, runtime, EndpointUnreachable, err)
+				continue
+			}
+			if !detected {
+				opts.logger().Infof("%s is reachable at endpoint %s but has no containers", runtime, endPoint)
+				opts.reportProbe(endPoint, runtime, EndpointEmpty, nil)
+				continue
+			}
+			opts.reportProbe(endPoint, runtime, EndpointDetected, nil)
+			found := newDetectedRuntime(runtime, ep.url)
+			if runtime == constants.CONTAINERD {
+				found.Namespace = ep.containerdNamespace()
+			}
+			found.ContainerCount, err = countContainers(ep, runtime, opts)
+			if err != nil {
+				opts.logger().Warn(err)
+			}
+			all = append(all, found)
+		}
+	}
+	if len(all) == 0 {
+		if permErr != nil {
+			return nil, permErr
+		}
+		return nil, errors.New("could not detect container runtime")
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if runtimePriority[all[i].Runtime] != runtimePriority[all[j].Runtime] {
+			return runtimePriority[all[i].Runtime] < runtimePriority[all[j].Runtime]
+		}
+		return all[i].SocketPath < all[j].SocketPath
+	})
+	return all, nil
+}
+
+// countContainers returns the number of containers of the runtime at the endpoint matching the label selector of opts
+func countContainers(ep *runtimeEndpoint, runtime string, opts Options) (int, error) {
+	selector, err := utils.ParseLabelSelector(opts.LabelSelector)
+	if err != nil {
+		return 0, err
+	}
+	if runtime == constants.DOCKER {
+		dockerCli, err := newDockerClient(ep)
+		if err != nil {
+			return 0, errors.Wrapf(err, " :error creating docker client")
+		}
+		defer dockerCli.Close()
+		containers, err := dockerCli.ContainerList(context.Background(), types.ContainerListOptions{
+			Quiet: true, All: true, Filters: selector.DockerFilters(),
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, " :error listing docker containers")
+		}
+		return len(containers), nil
+	}
+
+	return countContainerdContainers(ep, selector, opts)
+}
+
+// DetectionResult is the structured result of runtime detection, for reporting to a backend
+type DetectionResult struct {
+	Runtime    string    `json:"runtime"`
+	SocketPath string    `json:"socketPath"`
+	APIVersion string    `json:"apiVersion"`
+	DetectedAt time.Time `json:"detectedAt"`
+	// IsKubernetes is set when the runtime is managed by the kubelet of a kubernetes node
+	IsKubernetes bool `json:"isKubernetes"`
+	// ContainerdImageStore is set when the detected docker daemon keeps its images with containerd, in the moby
+	// namespace of the containerd socket also found on the node. The images are the same through either socket,
+	// so they should only be scanned through one of them.
+	ContainerdImageStore bool `json:"containerdImageStore,omitempty"`
+	// Rootless is set when the detected docker daemon runs rootless, see DockerInfo.Rootless. Like
+	// ContainerdImageStore it is left unset when the daemon's info can't be queried.
+	Rootless bool `json:"rootless,omitempty"`
+}
+
+// AutoDetectRuntimeResult auto detects the underlying container runtime like AutoDetectRuntime,
+// returning the result along with the runtime api version
+func AutoDetectRuntimeResult() (*DetectionResult, error) {
+	return autoDetectRuntimeResult(Options{})
+}
+
+func autoDetectRuntimeResult(opts Options) (*DetectionResult, error) {
+	runtime, sockPath, err := AutoDetectRuntimeWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &DetectionResult{
+		Runtime:    runtime,
+		SocketPath: sockPath,
+		DetectedAt: time.Now().UTC(),
+	}
+	result.APIVersion, err = runtimeAPIVersion(runtime, sockPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if runtime == constants.DOCKER {
+		// the daemon was detected and answered the version query, an /info failure only leaves
+		// ContainerdImageStore and Rootless unset
+		if info, err := queryDockerInfo(sockPath, opts); err != nil {
+			opts.logger().Warn(errors.Wrap(err, "could not query docker info"))
+		} else {
+			result.ContainerdImageStore = usesContainerdImageStore(info)
+			result.Rootless = isRootless(info)
+		}
+	}
+	result.IsKubernetes = criSocketExists()
+	if !result.IsKubernetes {
+		result.IsKubernetes, err = isKubernetesRuntime(runtime, sockPath, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// runtimeAPIVersion returns the docker api version or the containerd version of the runtime at sockPath
+func runtimeAPIVersion(runtime, sockPath string, opts Options) (string, error) {
+	_, apiVersion, err := queryRuntimeVersion(runtime, sockPath, opts)
+	return apiVersion, err
+}
+
+// queryRuntimeVersion returns the server version and api version of the runtime at sockPath,
+// containerd has no separate api version so its version is returned for both
+func queryRuntimeVersion(runtime, sockPath string, opts Options) (string, string, error) {
+	ep, err := resolveEndpoint(sockPath, opts)
+	if err != nil {
+		return "", "", err
+	}
+	if runtime == constants.DOCKER {
+		dockerCli, err := newDockerClient(ep)
+		if err != nil {
+			return "", "", errors.Wrapf(err, " :error creating docker client")
+		}
+		defer dockerCli.Close()
+		version, err := dockerCli.ServerVersion(context.Background())
+		if err != nil {
+			return "", "", errors.Wrapf(err, " :error querying docker version")
+		}
+		return version.Version, version.APIVersion, nil
+	}
+
+	version, err := containerdVersion(ep, opts)
+	if err != nil {
+		return "", "", err
+	}
+	return version, version, nil
+}
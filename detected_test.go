@@ -0,0 +1,24 @@
+package vessel
+
+import (
+	"github.com/deepfence/vessel/constants"
+	"testing"
+)
+
+func TestNewDetectedRuntimeNamespace(t *testing.T) {
+	tests := []struct {
+		runtime  string
+		sockPath string
+		want     string
+	}{
+		{constants.CONTAINERD, constants.DockerContainerdSocket, constants.CONTAINERD_MOBY_NS},
+		{constants.CONTAINERD, "/run/docker/containerd/containerd.sock", constants.CONTAINERD_MOBY_NS},
+		{constants.CONTAINERD, "unix:///run/containerd/containerd.sock", constants.CONTAINERD_K8S_NS},
+		{constants.DOCKER, "unix:///var/run/docker.sock", ""},
+	}
+	for _, tt := range tests {
+		if got := newDetectedRuntime(tt.runtime, tt.sockPath).Namespace; got != tt.want {
+			t.Errorf("newDetectedRuntime(%q, %q).Namespace = %q, want %q", tt.runtime, tt.sockPath, got, tt.want)
+		}
+	}
+}
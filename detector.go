@@ -0,0 +1,150 @@
+package vessel
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+// Detector detects the underlying container runtime, configured with functional options
+type Detector struct {
+	opts Options
+}
+
+// Option configures a Detector
+type Option func(*Options)
+
+// NewDetector returns a detector configured with the options, with no options it
+// probes the same endpoints as AutoDetectRuntime
+func NewDetector(opts ...Option) *Detector {
+	d := &Detector{}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	return d
+}
+
+// NewDetectorWithOptions returns a detector configured with an Options struct, the options are applied on top of it
+func NewDetectorWithOptions(base Options, opts ...Option) *Detector {
+	d := &Detector{opts: base}
+	for _, opt := range opts {
+		opt(&d.opts)
+	}
+	return d
+}
+
+// Detect probes the candidate endpoints until a runtime is detected. Detection stops
+// with the context error once ctx is done, abandoning the dial or probe in flight.
+func (d *Detector) Detect(ctx context.Context) (*DetectedRuntime, error) {
+	return detectRuntime(ctx, d.opts)
+}
+
+// FastDetect detects the runtime with the cheapest liveness call of each endpoint, a docker ping or the
+// containerd version, without listing containers. Unlike AutoDetectRuntime an idle runtime is detected,
+// which trades the running containers guarantee for the latency a readiness probe needs.
+func FastDetect(ctx context.Context) (*DetectedRuntime, error) {
+	return NewDetector(WithDetectBy(DaemonResponds)).Detect(ctx)
+}
+
+// DetectAll detects every active container runtime like DetectAllRuntimes
+func (d *Detector) DetectAll() ([]DetectedRuntime, error) {
+	return DetectAllRuntimesWithOptions(d.opts)
+}
+
+// Options returns the options the detector detects with
+func (d *Detector) Options() Options {
+	return d.opts
+}
+
+// WithTimeout bounds connecting to and querying each endpoint which doesn't set its own timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// WithNamespace probes the containerd namespace for containers instead of k8s.io
+func WithNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.Namespace = namespace
+	}
+}
+
+// WithNamespaceAutodetect probes the containerd namespace with the most containers instead of k8s.io
+func WithNamespaceAutodetect() Option {
+	return func(o *Options) {
+		o.DetectNamespace = true
+	}
+}
+
+// WithLogger sends the detection logs to logger instead of the standard logrus logger
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithEndpoints probes the endpoints before any other endpoint
+func WithEndpoints(endPoints ...Endpoint) Option {
+	return func(o *Options) {
+		o.Endpoints = append(o.Endpoints, endPoints...)
+	}
+}
+
+// WithTLS enables TLS on tcp endpoints
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(o *Options) {
+		o.TLSConfig = tlsConfig
+	}
+}
+
+// WithPreferredRuntime probes the endpoints of the runtime first
+func WithPreferredRuntime(runtime RuntimeType) Option {
+	return func(o *Options) {
+		o.PreferredRuntime = runtime
+	}
+}
+
+// WithDetectBy selects what counts as a detected runtime
+func WithDetectBy(detectBy DetectBy) Option {
+	return func(o *Options) {
+		o.DetectBy = detectBy
+	}
+}
+
+// WithLabelSelector only counts the containers whose labels match the selector towards detection
+func WithLabelSelector(selector string) Option {
+	return func(o *Options) {
+		o.LabelSelector = selector
+	}
+}
+
+// WithDialer connects to runtime sockets with the dialer
+func WithDialer(dialer *net.Dialer) Option {
+	return func(o *Options) {
+		o.Dialer = dialer
+	}
+}
+
+// WithDockerContext probes the endpoint of the current docker context before the default endpoints
+func WithDockerContext() Option {
+	return func(o *Options) {
+		o.UseDockerContext = true
+	}
+}
+
+// WithMetrics passes the probe metrics of detection to recorder
+func WithMetrics(recorder MetricsRecorder) Option {
+	return func(o *Options) {
+		o.Metrics = recorder
+	}
+}
+
+// WithProbeFunc calls fn with the outcome of each probed endpoint
+func WithProbeFunc(fn ProbeFunc) Option {
+	return func(o *Options) {
+		o.OnProbe = fn
+	}
+}
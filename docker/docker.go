@@ -2,8 +2,30 @@ package docker
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // New instantiates a new Docker runtime object
@@ -13,6 +35,22 @@ func New() *Docker {
 	}
 }
 
+// NewWithSocket instantiates a new Docker runtime object using the given socket
+func NewWithSocket(socketPath string) *Docker {
+	return &Docker{
+		socketPath: socketPath,
+	}
+}
+
+// NewWithWorkDir instantiates a new Docker runtime object using the given socket, spooling the images and
+// layers it streams or opens to workDir, for nodes whose temp dir is a small tmpfs
+func NewWithWorkDir(socketPath, workDir string) *Docker {
+	return &Docker{
+		socketPath: socketPath,
+		workDir:    workDir,
+	}
+}
+
 // GetSocket is socket getter
 func (d Docker) GetSocket() string {
 	return d.socketPath
@@ -50,12 +88,721 @@ func (d Docker) ExtractImage(imageID, imageName, path string) error {
 	return nil
 }
 
-// GetImageID returns the image id
+// ExtractImageWithProgress is ExtractImage reporting progress as the image tarball is extracted
+func (d Docker) ExtractImageWithProgress(imageID, imageName, path string, progress models.ProgressFunc) error {
+	return d.ExtractImageWithOptions(imageID, imageName, path, models.ExtractOptions{Progress: progress})
+}
+
+// ExtractImageWithOptions is ExtractImage with progress reporting, digest verification and extraction limits
+func (d Docker) ExtractImageWithOptions(imageID, imageName, path string, opts models.ExtractOptions) error {
+	err := d.extractImage(imageID, imageName, path, opts)
+	if err != nil {
+		return err
+	}
+	if opts.VerifyDigests {
+		return utils.VerifyImageDigests(path)
+	}
+	return nil
+}
+
+// extractImage extracts the image tarball, reporting progress and enforcing the limits when set
+func (d Docker) extractImage(imageID, imageName, path string, opts models.ExtractOptions) error {
+	if opts.Progress == nil && opts.MaxFiles == 0 && opts.MaxBytes == 0 {
+		return d.ExtractImage(imageID, imageName, path)
+	}
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+
+	var total int64
+	if image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageID); err == nil {
+		total = image.Size
+	}
+	imageTar, err := dockerCli.ImageSave(context.Background(), []string{imageID})
+	if err != nil {
+		return err
+	}
+	defer imageTar.Close()
+
+	var stderr bytes.Buffer
+	extract := exec.Command("tar", "xf", "-", "--warning=none", "-C"+path)
+	extract.Stderr = &stderr
+	pipe, err := extract.StdinPipe()
+	if err != nil {
+		return err
+	}
+	err = extract.Start()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	err = utils.CopyImageTar(pipe, imageTar, total, opts)
+	if err != nil {
+		pipe.Close()
+		extract.Wait()
+		return err
+	}
+	err = pipe.Close()
+	if err != nil {
+		return err
+	}
+	err = extract.Wait()
+	if err != nil {
+		return errors.New(stderr.String())
+	}
+	return nil
+}
+
+// ExtractFlattenedFS extracts the image and merges its layers into a single filesystem tar at outputTarPath with
+// the whiteouts applied, see utils.FlattenLayers
+func (d Docker) ExtractFlattenedFS(imageName, outputTarPath string) error {
+	return d.ExtractFlattenedFSWithOptions(imageName, outputTarPath, models.ExtractOptions{})
+}
+
+// ExtractFlattenedFSWithOptions is ExtractFlattenedFS with the options of ExtractImageWithOptions, the MaxFiles
+// and MaxBytes limits apply to both the extraction and the flattened tar
+func (d Docker) ExtractFlattenedFSWithOptions(imageName, outputTarPath string, opts models.ExtractOptions) error {
+	return utils.ExtractFlattened(outputTarPath, opts, func(dir string) error {
+		return d.ExtractImageWithOptions(imageName, imageName, dir, opts)
+	})
+}
+
+// GetImageID returns the image id, imageName may also be a short image id
 func (d Docker) GetImageID(imageName string) ([]byte, error) {
-	return exec.Command("docker", "images", "-q", "--no-trunc", imageName).Output()
+	imageID, err := exec.Command("docker", "images", "-q", "--no-trunc", imageName).Output()
+	if err != nil || len(bytes.TrimSpace(imageID)) > 0 {
+		return imageID, err
+	}
+	// docker images only matches repository names, unlike inspect which also resolves short image ids
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageName)
+	if client.IsErrNotFound(err) {
+		return imageID, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(image.ID + "\n"), nil
+}
+
+// GetImageIDs returns the image ids of the named images using a single image listing,
+// names that don't resolve to an image map to an empty id
+func (d Docker) GetImageIDs(names []string) (map[string]string, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	images, err := dockerCli.ImageList(context.Background(), types.ImageListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, image := range images {
+		refs[image.ID] = image.ID
+		for _, tag := range image.RepoTags {
+			refs[tag] = image.ID
+		}
+		for _, repoDigest := range image.RepoDigests {
+			refs[repoDigest] = image.ID
+		}
+	}
+	imageIDs := make(map[string]string, len(names))
+	for _, name := range names {
+		imageID, ok := refs[name]
+		if !ok && !strings.ContainsAny(name, ":@") {
+			imageID = refs[name+":latest"]
+		}
+		imageIDs[name] = imageID
+	}
+	return imageIDs, nil
+}
+
+// ListImages returns the images stored by the docker daemon. The repository and pattern are filtered
+// on by the daemon's reference filter, the regexp and a pattern along with a repository client side,
+// since the daemon ORs reference filters.
+func (d Docker) ListImages(namespace string, opts models.ListImagesOptions) ([]models.ImageInfo, error) {
+	nameFilter, err := utils.NewImageNameFilter(opts.Pattern, opts.Regexp)
+	if err != nil {
+		return nil, err
+	}
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	listOpts := types.ImageListOptions{}
+	switch {
+	case opts.Repository != "":
+		listOpts.Filters = filters.NewArgs(filters.Arg("reference", opts.Repository))
+	case opts.Pattern != "":
+		listOpts.Filters = filters.NewArgs(filters.Arg("reference", opts.Pattern))
+	}
+	images, err := dockerCli.ImageList(context.Background(), listOpts)
+	if err != nil {
+		return nil, err
+	}
+	imageInfos := make([]models.ImageInfo, 0, len(images))
+	for _, image := range images {
+		repoTags := image.RepoTags
+		if !nameFilter.IsEmpty() {
+			repoTags = nil
+			for _, tag := range image.RepoTags {
+				if nameFilter.Matches(tag) {
+					repoTags = append(repoTags, tag)
+				}
+			}
+			if len(repoTags) == 0 {
+				continue
+			}
+		}
+		imageInfos = append(imageInfos, models.ImageInfo{
+			ID:       image.ID,
+			RepoTags: repoTags,
+			Size:     image.Size,
+			Created:  time.Unix(image.Created, 0),
+		})
+	}
+	return imageInfos, nil
 }
 
 // Save just saves image using -o flag
 func (d Docker) Save(imageName, outputParam string) ([]byte, error) {
 	return exec.Command("docker", "save", imageName, "-o", outputParam).Output()
 }
+
+// SaveCompressed saves the image to outputPath with the given compression,
+// returning the output path with the compression's file extension
+func (d Docker) SaveCompressed(imageName, outputPath string, compression models.Compression) (string, error) {
+	if compression == models.CompressionNone {
+		_, err := d.Save(imageName, outputPath)
+		return outputPath, err
+	}
+	outputPath = utils.CompressedOutputPath(outputPath, compression)
+	return outputPath, utils.SaveCompressed(exec.Command("docker", "save", imageName), outputPath, compression)
+}
+
+// GetContainerDiff returns the files changed in the container's writable layer relative to its image
+func (d Docker) GetContainerDiff(containerID, namespace string) ([]models.ChangedFile, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	changes, err := dockerCli.ContainerDiff(context.Background(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	changedFiles := make([]models.ChangedFile, 0, len(changes))
+	for _, change := range changes {
+		changedFiles = append(changedFiles, models.ChangedFile{
+			Path: change.Path,
+			Kind: changeKinds[change.Kind],
+		})
+	}
+	return changedFiles, nil
+}
+
+// PullImage pulls the image through the docker daemon.
+// Registry TLS settings are daemon configuration for docker (insecure-registries in daemon.json,
+// CA certs under /etc/docker/certs.d), so pull options asking for them are rejected.
+func (d Docker) PullImage(imageRef, namespace string, opts models.PullOptions) error {
+	if opts.InsecureSkipTLSVerify || opts.RegistryCAFile != "" || opts.PlainHTTP {
+		return errors.New("registry TLS options are not supported for docker, configure insecure-registries " +
+			"or /etc/docker/certs.d on the docker daemon instead")
+	}
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+
+	pullOpts := types.ImagePullOptions{}
+	pullOpts.RegistryAuth, err = registryAuthHeader(imageRef, opts.Auth)
+	if err != nil {
+		return err
+	}
+	progress, err := dockerCli.ImagePull(context.Background(), imageRef, pullOpts)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+	return jsonmessage.DisplayJSONMessagesStream(progress, ioutil.Discard, 0, false, nil)
+}
+
+// registryAuthHeader returns the X-Registry-Auth value for the registry of imageRef, empty for anonymous access
+func registryAuthHeader(imageRef string, auth models.AuthConfig) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %s: %v", imageRef, err)
+	}
+	host := reference.Domain(named)
+	auth, err = utils.RegistryAuth(auth, host)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username == "" && auth.Password == "" && auth.IdentityToken == "" {
+		return "", nil
+	}
+	authJSON, err := json.Marshal(types.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		IdentityToken: auth.IdentityToken,
+		ServerAddress: host,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(authJSON), nil
+}
+
+// SubscribeContainerEvents streams container lifecycle events until ctx is cancelled
+func (d Docker) SubscribeContainerEvents(ctx context.Context, namespace string) (<-chan models.Event, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	messages, errs := dockerCli.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", events.ContainerEventType)),
+	})
+	containerEvents := make(chan models.Event)
+	go func() {
+		defer close(containerEvents)
+		defer dockerCli.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-errs:
+				return
+			case message := <-messages:
+				event := models.Event{
+					ID:        message.Actor.ID,
+					Action:    message.Action,
+					Timestamp: time.Unix(0, message.TimeNano),
+				}
+				select {
+				case containerEvents <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return containerEvents, nil
+}
+
+// GetContainerRootfsPath returns the merged overlay2 directory of a running container,
+// there is nothing to clean up since the directory is owned by the docker daemon
+func (d Docker) GetContainerRootfsPath(containerID, namespace string) (string, func() error, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return "", nil, err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return "", nil, err
+	}
+	if container.GraphDriver.Name != "overlay2" {
+		return "", nil, fmt.Errorf("storage driver %s is not supported, only overlay2", container.GraphDriver.Name)
+	}
+	mergedDir, ok := container.GraphDriver.Data["MergedDir"]
+	if !ok {
+		return "", nil, errors.New("container rootfs is not mounted, the container may not be running")
+	}
+	return mergedDir, func() error { return nil }, nil
+}
+
+// ExportImageFilesystem writes the flattened filesystem of the image to w as a tar archive. A container is
+// created from the image without being started, exported and removed, so the archive also has the few files
+// docker adds to every container, like /.dockerenv and the empty /etc/hosts, /etc/hostname and /etc/resolv.conf.
+func (d Docker) ExportImageFilesystem(imageRef, namespace string, w io.Writer) error {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+	ctx := context.Background()
+	// the entrypoint is never run, it only lets images without a command be created
+	created, err := dockerCli.ContainerCreate(ctx, &container.Config{
+		Image:      imageRef,
+		Entrypoint: strslice.StrSlice{"/vessel-export"},
+		Labels:     map[string]string{"io.deepfence.vessel.export": "true"},
+	}, nil, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer dockerCli.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+	rootfsTar, err := dockerCli.ContainerExport(ctx, created.ID)
+	if err != nil {
+		return err
+	}
+	defer rootfsTar.Close()
+	_, err = io.Copy(w, rootfsTar)
+	return err
+}
+
+// GetContainerStatus returns the normalized state of the container
+func (d Docker) GetContainerStatus(containerID, namespace string) (string, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return "", err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return "", err
+	}
+	return normalizeState(container.State), nil
+}
+
+// PauseContainer freezes the processes of the running container, failing when it isn't running
+func (d Docker) PauseContainer(containerID, namespace string) error {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return err
+	}
+	if state := normalizeState(container.State); state != models.ContainerRunning {
+		return fmt.Errorf("container %s is not running, it is %s", containerID, state)
+	}
+	return dockerCli.ContainerPause(context.Background(), containerID)
+}
+
+// UnpauseContainer resumes the processes of the paused container, failing when it isn't paused
+func (d Docker) UnpauseContainer(containerID, namespace string) error {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return err
+	}
+	if state := normalizeState(container.State); state != models.ContainerPaused {
+		return fmt.Errorf("container %s is not paused, it is %s", containerID, state)
+	}
+	return dockerCli.ContainerUnpause(context.Background(), containerID)
+}
+
+// GetContainerInfo returns the name, image, creation and start times and normalized state of the container
+func (d Docker) GetContainerInfo(containerID, namespace string) (*models.ContainerInfo, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	info := &models.ContainerInfo{
+		ID:    container.ID,
+		Name:  strings.TrimPrefix(container.Name, "/"),
+		State: normalizeState(container.State),
+	}
+	if container.Config != nil {
+		info.Image = container.Config.Image
+	}
+	// inspect reports times as RFC 3339 strings, 0001-01-01T00:00:00Z for a container never started
+	info.Created, _ = time.Parse(time.RFC3339Nano, container.Created)
+	if container.State != nil {
+		info.StartedAt, _ = time.Parse(time.RFC3339Nano, container.State.StartedAt)
+	}
+	return info, nil
+}
+
+// normalizeState maps the docker container status to the normalized container states
+func normalizeState(state *types.ContainerState) string {
+	if state == nil {
+		return models.ContainerExited
+	}
+	switch state.Status {
+	case "running", "restarting":
+		return models.ContainerRunning
+	case "paused":
+		return models.ContainerPaused
+	case "created":
+		return models.ContainerCreated
+	default:
+		return models.ContainerExited
+	}
+}
+
+// GetContainerPID returns the host pid of the container's init process
+func (d Docker) GetContainerPID(containerID, namespace string) (int, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return 0, err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return 0, err
+	}
+	if container.State.Pid == 0 {
+		return 0, fmt.Errorf("container %s is not running", containerID)
+	}
+	return container.State.Pid, nil
+}
+
+// GetContainerMounts returns the volumes, host binds and tmpfs mounts of the container, named pipes of
+// windows containers are reported as binds
+func (d Docker) GetContainerMounts(containerID, namespace string) ([]models.Mount, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	mounts := make([]models.Mount, 0, len(container.Mounts))
+	for _, mountPoint := range container.Mounts {
+		mountType := models.MountBind
+		switch mountPoint.Type {
+		case mount.TypeVolume:
+			mountType = models.MountVolume
+		case mount.TypeTmpfs:
+			mountType = models.MountTmpfs
+		}
+		mounts = append(mounts, models.Mount{
+			Source:      mountPoint.Source,
+			Destination: mountPoint.Destination,
+			Type:        mountType,
+			RW:          mountPoint.RW,
+		})
+	}
+	return mounts, nil
+}
+
+// CheckpointContainer checkpoints the running container with CRIU, leaving it running. The checkpoint is
+// written to outDir/checkpoint, docker only supports checkpoints when its experimental features are enabled.
+func (d Docker) CheckpointContainer(containerID, namespace, outDir string) error {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return err
+	}
+	defer dockerCli.Close()
+	return dockerCli.CheckpointCreate(context.Background(), containerID, types.CheckpointCreateOptions{
+		CheckpointID:  "checkpoint",
+		CheckpointDir: outDir,
+	})
+}
+
+// GetContainerLogs returns the stdout and stderr of the container, interleaved as written
+func (d Docker) GetContainerLogs(containerID, namespace string, opts models.LogOptions) (io.ReadCloser, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		dockerCli.Close()
+		return nil, err
+	}
+	logOpts := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       "all",
+	}
+	if !opts.Since.IsZero() {
+		logOpts.Since = strconv.FormatInt(opts.Since.Unix(), 10)
+	}
+	if opts.Tail > 0 {
+		logOpts.Tail = strconv.Itoa(opts.Tail)
+	}
+	logs, err := dockerCli.ContainerLogs(context.Background(), containerID, logOpts)
+	if err != nil {
+		dockerCli.Close()
+		return nil, err
+	}
+	if container.Config != nil && container.Config.Tty {
+		return &logStream{ReadCloser: logs, client: dockerCli}, nil
+	}
+	// without a tty docker multiplexes stdout and stderr in one stream
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, logs)
+		pipeWriter.CloseWithError(err)
+	}()
+	return &logStream{ReadCloser: pipeReader, logs: logs, client: dockerCli}, nil
+}
+
+// ListContainerIDs returns the full ids of all containers, including stopped ones
+func (d Docker) ListContainerIDs(namespace string) ([]string, error) {
+	return d.ListContainers(namespace, models.ListContainersOptions{})
+}
+
+// ListContainers returns the ids of the containers matching the options, the label selector
+// is passed to the daemon as label filters
+func (d Docker) ListContainers(namespace string, opts models.ListContainersOptions) ([]string, error) {
+	selector, err := utils.ParseLabelSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	containers, err := dockerCli.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: selector.DockerFilters(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+	return ids, nil
+}
+
+// GetImageLayers returns the ordered layer diff ids of the image
+func (d Docker) GetImageLayers(imageRef, namespace string) ([]string, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageRef)
+	if err != nil {
+		return nil, err
+	}
+	return image.RootFS.Layers, nil
+}
+
+// GetImageLabels returns the labels of the image config, an empty map when it has none
+func (d Docker) GetImageLabels(imageName string) (map[string]string, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageName)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	if image.Config != nil {
+		for key, value := range image.Config.Labels {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
+
+// GetImageDigest returns the registry digest of the image from its repo digests, preferring the digest of the
+// repository imageRef names when the image was pulled from several. Images which were built locally or loaded
+// from an archive have no repo digest.
+func (d Docker) GetImageDigest(imageRef, namespace string) (string, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return "", err
+	}
+	defer dockerCli.Close()
+	image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageRef)
+	if err != nil {
+		return "", err
+	}
+	if len(image.RepoDigests) == 0 {
+		return "", fmt.Errorf("image %s has no repo digest, it wasn't pulled from a registry", imageRef)
+	}
+	repoDigest := image.RepoDigests[0]
+	if named, err := reference.ParseNormalizedNamed(imageRef); err == nil {
+		for _, candidate := range image.RepoDigests {
+			if digested, err := reference.ParseNormalizedNamed(candidate); err == nil && digested.Name() == named.Name() {
+				repoDigest = candidate
+				break
+			}
+		}
+	}
+	return repoDigest[strings.LastIndex(repoDigest, "@")+1:], nil
+}
+
+// GetImageHistory returns the build steps of the image oldest first, along with the diff ids of their layers.
+// Docker's history api doesn't flag empty layers, so steps without size count as empty as long as enough
+// steps remain for the remaining layers.
+func (d Docker) GetImageHistory(imageRef, namespace string) ([]models.HistoryEntry, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	image, _, err := dockerCli.ImageInspectWithRaw(context.Background(), imageRef)
+	if err != nil {
+		return nil, err
+	}
+	history, err := dockerCli.ImageHistory(context.Background(), imageRef)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := image.RootFS.Layers
+	entries := make([]models.HistoryEntry, 0, len(history))
+	// the history api returns the newest step first
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := models.HistoryEntry{
+			Created:   time.Unix(history[i].Created, 0).UTC(),
+			CreatedBy: history[i].CreatedBy,
+		}
+		remainingSteps := i + 1
+		if len(diffIDs) > 0 && (history[i].Size > 0 || remainingSteps <= len(diffIDs)) {
+			entry.DiffID, diffIDs = diffIDs[0], diffIDs[1:]
+		} else {
+			entry.EmptyLayer = true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetContainerImage returns the image the container was created from,
+// the digest is the local image id the container's image name resolved to
+func (d Docker) GetContainerImage(containerID, namespace string) (models.ImageRef, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return models.ImageRef{}, err
+	}
+	defer dockerCli.Close()
+	container, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return models.ImageRef{}, err
+	}
+	return models.ImageRef{
+		Name:   container.Config.Image,
+		Digest: container.Image,
+	}, nil
+}
+
+// newClient creates a docker api client connected to the runtime socket, for the calls of
+// one method. Its connections are torn down after each response, see DisableKeepAlives.
+func (d Docker) newClient() (*client.Client, error) {
+	return client.NewClientWithOpts(client.WithAPIVersionNegotiation(), client.WithHost(d.socketPath), DisableKeepAlives())
+}
+
+// DisableKeepAlives turns off http keep-alives for a docker client used for a few calls. Closing a client only
+// closes the connections idle at that point, a connection returned to the pool later, like the one of a stream
+// closed after the client, would otherwise stay open for as long as the process runs.
+func DisableKeepAlives() client.Opt {
+	return func(c *client.Client) error {
+		if transport, ok := c.HTTPClient().Transport.(*http.Transport); ok {
+			transport.DisableKeepAlives = true
+		}
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/deepfence/vessel/models"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// layerFile is the temporary file a layer was copied to, closing it removes the file
+type layerFile struct {
+	*os.File
+}
+
+func (l *layerFile) Close() error {
+	err := l.File.Close()
+	os.Remove(l.Name())
+	return err
+}
+
+// OpenLayer opens the uncompressed tar of the image's layer for random access, for reading a file at a known offset
+// without extracting the image. Docker has no api to read a single layer, so the image is saved and only the entry
+// of the layer is copied from the save stream to a temporary file of the work dir, see NewWithWorkDir. layerDigest
+// is a diff id of the image config, as reported by ExtractImageStream, or the archive directory id of the layer for
+// older daemons. Closing the returned reader removes the temporary file.
+func (d Docker) OpenLayer(imageName, layerDigest string) (models.LayerReader, int64, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer dockerCli.Close()
+	body, err := dockerCli.ImageSave(context.Background(), []string{imageName})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+	layer, size, err := copyLayerEntry(body, d.workDir, layerDigest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("layer %s of image %s: %v", layerDigest, imageName, err)
+	}
+	return layer, size, nil
+}
+
+// copyLayerEntry copies the layer tar with the digest from the save stream r to a temporary file of workDir.
+// OCI layout blobs and legacy archive directories named by the digest are copied right away, the other legacy
+// layer tars are copied while hashed and dropped once their diff id doesn't match.
+func copyLayerEntry(r io.Reader, workDir, layerDigest string) (*layerFile, int64, error) {
+	want := strings.TrimPrefix(layerDigest, "sha256:")
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, 0, fmt.Errorf("no layer with digest %s", layerDigest)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			// layers shared by several archive directories are symlinked to the first one
+			continue
+		}
+		dir, file := path.Split(path.Clean(header.Name))
+		dir = path.Clean(dir)
+		var digest hash.Hash
+		switch {
+		case dir == "blobs/sha256" && file == want, file == "layer.tar" && dir == want:
+		case file == "layer.tar":
+			// legacy archive directories are named by v1 ids, the diff id is the digest of the layer tar
+			digest = sha256.New()
+		default:
+			continue
+		}
+		layer, err := copyEntry(tr, workDir, digest)
+		if err != nil {
+			return nil, 0, err
+		}
+		if digest != nil && hex.EncodeToString(digest.Sum(nil)) != want {
+			layer.Close()
+			continue
+		}
+		return layer, header.Size, nil
+	}
+}
+
+// copyEntry copies the tar entry being read to a new temporary file of workDir, hashing it when digest is set
+func copyEntry(r io.Reader, workDir string, digest hash.Hash) (*layerFile, error) {
+	f, err := ioutil.TempFile(workDir, "vessel-layer-")
+	if err != nil {
+		return nil, err
+	}
+	layer := &layerFile{File: f}
+	w := io.Writer(f)
+	if digest != nil {
+		w = io.MultiWriter(f, digest)
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		layer.Close()
+		return nil, fmt.Errorf("failed to copy layer: %v", err)
+	}
+	return layer, nil
+}
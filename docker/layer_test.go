@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCopyLayerEntry(t *testing.T) {
+	base, app := layerTar(t, "base"), layerTar(t, "app")
+	config := []byte(`{"rootfs":{}}`)
+	tests := []struct {
+		name   string
+		digest string
+		save   []byte
+	}{
+		{
+			name:   "oci layout blob",
+			digest: diffID(app),
+			save:   saveTar(t, "blobs/sha256/"+diffID(base)[7:], base, "blobs/sha256/cfg", config, "blobs/sha256/"+diffID(app)[7:], app),
+		},
+		{
+			name:   "legacy layer matched on its diff id",
+			digest: diffID(app),
+			save:   saveTar(t, "f00d/json", config, "f00d/layer.tar", base, "beef/layer.tar", app, "manifest.json", []byte("[]")),
+		},
+		{
+			name:   "legacy layer matched on its archive directory",
+			digest: "sha256:beef",
+			save:   saveTar(t, "f00d/layer.tar", base, "beef/layer.tar", app),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			layer, size, err := copyLayerEntry(bytes.NewReader(tt.save), workDir, tt.digest)
+			if err != nil {
+				t.Fatalf("copyLayerEntry() error = %v", err)
+			}
+			if size != int64(len(app)) {
+				t.Errorf("size = %d, want %d", size, len(app))
+			}
+			// read the header of the layer's only file and its content at their offsets
+			got := make([]byte, 512)
+			if _, err = layer.ReadAt(got, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, app[:512]) {
+				t.Error("ReadAt(0) doesn't return the start of the layer tar")
+			}
+			got = make([]byte, 3)
+			if _, err = layer.ReadAt(got, 512); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "app" {
+				t.Errorf("ReadAt(512) = %q, want %q", got, "app")
+			}
+			if files, _ := ioutil.ReadDir(workDir); len(files) != 1 {
+				t.Errorf("work dir holds %d files, want only the layer", len(files))
+			}
+			if err = layer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(layer.Name()); !os.IsNotExist(err) {
+				t.Errorf("layer file still exists after Close: %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyLayerEntryUnknownDigest(t *testing.T) {
+	base := layerTar(t, "base")
+	workDir := t.TempDir()
+	save := saveTar(t, "blobs/sha256/"+diffID(base)[7:], base, "f00d/layer.tar", base)
+	if _, _, err := copyLayerEntry(bytes.NewReader(save), workDir, diffID(layerTar(t, "other"))); err == nil {
+		t.Fatal("copyLayerEntry() of an unknown digest succeeded")
+	}
+	if files, _ := ioutil.ReadDir(workDir); len(files) != 0 {
+		t.Errorf("work dir holds %d files after a failed copy, want none", len(files))
+	}
+}
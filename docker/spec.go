@@ -0,0 +1,258 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"strconv"
+	"strings"
+)
+
+// defaultCapabilities are the capabilities docker grants containers unless told otherwise
+var defaultCapabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FSETID", "CAP_FOWNER", "CAP_MKNOD", "CAP_NET_RAW", "CAP_SETGID",
+	"CAP_SETUID", "CAP_SETFCAP", "CAP_SETPCAP", "CAP_NET_BIND_SERVICE", "CAP_SYS_CHROOT", "CAP_KILL", "CAP_AUDIT_WRITE",
+}
+
+// allCapabilities are the capabilities of privileged containers and of --cap-add ALL
+var allCapabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID",
+	"CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN",
+	"CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE", "CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ",
+}
+
+// GetContainerSpec returns an approximation of the OCI runtime spec of the container. Docker doesn't expose the
+// config.json it hands to the runtime, so the spec is rebuilt from inspect: the process, root, hostname, mounts,
+// capabilities, namespaces, resources, masked paths and security options. Capabilities are docker's defaults
+// adjusted by --cap-add and --cap-drop, the seccomp profile is only set for a custom profile, which docker inlines
+// in the security options, and otherwise has just the default errno action of docker's default profile. Devices,
+// hooks, rlimits and the cgroup path are left out.
+func (d Docker) GetContainerSpec(containerID, namespace string) (*specs.Spec, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerCli.Close()
+	inspect, err := dockerCli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	return specFromInspect(inspect), nil
+}
+
+// specFromInspect rebuilds the OCI spec of a container from its inspect response
+func specFromInspect(inspect types.ContainerJSON) *specs.Spec {
+	hostConfig := inspect.HostConfig
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+	config := inspect.Config
+	if config == nil {
+		config = &container.Config{}
+	}
+	securityOpts := parseSecurityOpts(hostConfig.SecurityOpt)
+
+	capabilities := containerCapabilities(hostConfig.CapAdd, hostConfig.CapDrop, hostConfig.Privileged)
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{
+			Terminal: config.Tty,
+			User:     parseUser(config.User),
+			Args:     append([]string{inspect.Path}, inspect.Args...),
+			Env:      config.Env,
+			Cwd:      config.WorkingDir,
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  capabilities,
+				Effective: capabilities,
+				Permitted: capabilities,
+			},
+			NoNewPrivileges: securityOpts["no-new-privileges"] != "" && securityOpts["no-new-privileges"] != "false",
+			ApparmorProfile: inspect.AppArmorProfile,
+			OOMScoreAdj:     &hostConfig.OomScoreAdj,
+			SelinuxLabel:    inspect.ProcessLabel,
+		},
+		Root: &specs.Root{
+			Readonly: hostConfig.ReadonlyRootfs,
+		},
+		Hostname: config.Hostname,
+		Linux: &specs.Linux{
+			Namespaces:    containerNamespaces(hostConfig),
+			MaskedPaths:   hostConfig.MaskedPaths,
+			ReadonlyPaths: hostConfig.ReadonlyPaths,
+			Resources:     containerResources(hostConfig.Resources),
+			Seccomp:       containerSeccomp(securityOpts["seccomp"], hostConfig.Privileged),
+			MountLabel:    inspect.MountLabel,
+			Sysctl:        hostConfig.Sysctls,
+		},
+		Annotations: config.Labels,
+	}
+	if inspect.GraphDriver.Data != nil {
+		spec.Root.Path = inspect.GraphDriver.Data["MergedDir"]
+	}
+	for _, mountPoint := range inspect.Mounts {
+		// volumes are bind mounted from their data directory
+		mountType, options := "bind", []string{"rbind"}
+		if mountPoint.Type == "tmpfs" {
+			mountType, options = "tmpfs", nil
+		}
+		if mountPoint.RW {
+			options = append(options, "rw")
+		} else {
+			options = append(options, "ro")
+		}
+		if mountPoint.Propagation != "" {
+			options = append(options, string(mountPoint.Propagation))
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: mountPoint.Destination,
+			Type:        mountType,
+			Source:      mountPoint.Source,
+			Options:     options,
+		})
+	}
+	return spec
+}
+
+// parseSecurityOpts maps the security options to their values, like seccomp=unconfined,
+// options without a value map to true
+func parseSecurityOpts(securityOpts []string) map[string]string {
+	parsed := make(map[string]string, len(securityOpts))
+	for _, opt := range securityOpts {
+		// docker accepts both key=value and the legacy key:value
+		i := strings.IndexAny(opt, "=:")
+		if i < 0 {
+			parsed[opt] = "true"
+			continue
+		}
+		parsed[opt[:i]] = opt[i+1:]
+	}
+	return parsed
+}
+
+// parseUser parses numeric user[:group] ids, user names can't be resolved without the container's /etc/passwd
+func parseUser(user string) specs.User {
+	var parsed specs.User
+	parts := strings.SplitN(user, ":", 2)
+	if uid, err := strconv.ParseUint(parts[0], 10, 32); err == nil {
+		parsed.UID = uint32(uid)
+	}
+	if len(parts) == 2 {
+		if gid, err := strconv.ParseUint(parts[1], 10, 32); err == nil {
+			parsed.GID = uint32(gid)
+		}
+	}
+	return parsed
+}
+
+// containerCapabilities applies --cap-add and --cap-drop to docker's default capabilities
+func containerCapabilities(capAdd, capDrop []string, privileged bool) []string {
+	if privileged {
+		return allCapabilities
+	}
+	adds, drops := normalizeCapabilities(capAdd), normalizeCapabilities(capDrop)
+	basics := defaultCapabilities
+	if containsCapability(adds, "ALL") {
+		basics = allCapabilities
+	}
+	var capabilities []string
+	for _, capability := range basics {
+		if !containsCapability(drops, "ALL") && !containsCapability(drops, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	for _, capability := range adds {
+		if capability != "ALL" && !containsCapability(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// normalizeCapabilities returns the capabilities in their CAP_ prefixed upper case form, ALL is kept as is
+func normalizeCapabilities(capabilities []string) []string {
+	normalized := make([]string, 0, len(capabilities))
+	for _, capability := range capabilities {
+		capability = strings.ToUpper(capability)
+		if capability != "ALL" && !strings.HasPrefix(capability, "CAP_") {
+			capability = "CAP_" + capability
+		}
+		normalized = append(normalized, capability)
+	}
+	return normalized
+}
+
+func containsCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// containerNamespaces returns the namespaces the container doesn't share with the host or another container
+func containerNamespaces(hostConfig *container.HostConfig) []specs.LinuxNamespace {
+	namespaces := []specs.LinuxNamespace{{Type: specs.MountNamespace}}
+	if !hostConfig.PidMode.IsHost() && !hostConfig.PidMode.IsContainer() {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.PIDNamespace})
+	}
+	if !hostConfig.NetworkMode.IsHost() && !hostConfig.NetworkMode.IsContainer() {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.NetworkNamespace})
+	}
+	if !hostConfig.IpcMode.IsHost() && !hostConfig.IpcMode.IsContainer() {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.IPCNamespace})
+	}
+	if !hostConfig.UTSMode.IsHost() {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.UTSNamespace})
+	}
+	if hostConfig.CgroupnsMode.IsPrivate() {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.CgroupNamespace})
+	}
+	if hostConfig.UsernsMode.IsPrivate() && hostConfig.UsernsMode != "" {
+		namespaces = append(namespaces, specs.LinuxNamespace{Type: specs.UserNamespace})
+	}
+	return namespaces
+}
+
+// containerResources returns the memory, cpu and pids limits of the container
+func containerResources(resources container.Resources) *specs.LinuxResources {
+	linuxResources := &specs.LinuxResources{}
+	if resources.Memory > 0 {
+		linuxResources.Memory = &specs.LinuxMemory{Limit: &resources.Memory}
+	}
+	if resources.CPUShares > 0 || resources.CPUQuota > 0 || resources.CpusetCpus != "" {
+		linuxResources.CPU = &specs.LinuxCPU{Cpus: resources.CpusetCpus, Quota: &resources.CPUQuota}
+		if resources.CPUShares > 0 {
+			shares := uint64(resources.CPUShares)
+			linuxResources.CPU.Shares = &shares
+		}
+		if resources.CPUPeriod > 0 {
+			period := uint64(resources.CPUPeriod)
+			linuxResources.CPU.Period = &period
+		}
+	}
+	if resources.PidsLimit != nil && *resources.PidsLimit > 0 {
+		linuxResources.Pids = &specs.LinuxPids{Limit: *resources.PidsLimit}
+	}
+	return linuxResources
+}
+
+// containerSeccomp returns the seccomp profile of the container, nil when it runs unconfined
+func containerSeccomp(profile string, privileged bool) *specs.LinuxSeccomp {
+	if privileged || profile == "unconfined" {
+		return nil
+	}
+	if profile != "" {
+		// docker's profile format is a superset of the spec's seccomp section
+		var seccomp specs.LinuxSeccomp
+		if err := json.Unmarshal([]byte(profile), &seccomp); err == nil {
+			return &seccomp
+		}
+	}
+	return &specs.LinuxSeccomp{DefaultAction: specs.ActErrno}
+}
@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// ExtractImageStream streams the layers of the image from the docker save stream one at a time, in the layer order
+// of the image config. Digest is the layer's diff id, like sha256:..., as containerd reports it. The save stream
+// is parsed as it arrives and a layer in its place is sent right away, while the archive order doesn't always match
+// the image's layer order, so layers arriving ahead of their turn are spooled to the work dir, see NewWithWorkDir.
+// The diff ids of the layers of legacy saves have to be computed, so those layers are always spooled.
+func (d Docker) ExtractImageStream(ctx context.Context, imageName, namespace string) (<-chan models.LayerResult, error) {
+	dockerCli, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	image, _, err := dockerCli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		dockerCli.Close()
+		return nil, err
+	}
+	body, err := dockerCli.ImageSave(ctx, []string{imageName})
+	if err != nil {
+		dockerCli.Close()
+		return nil, err
+	}
+	layers := make(chan models.LayerResult)
+	go func() {
+		defer close(layers)
+		defer dockerCli.Close()
+		defer body.Close()
+		spool := &layerSpool{order: image.RootFS.Layers, workDir: d.workDir, files: map[string]string{}}
+		defer spool.Close()
+		if err := spool.stream(ctx, body, layers); err != nil && ctx.Err() == nil {
+			utils.SendLayerError(ctx, layers, err)
+		}
+	}()
+	return layers, nil
+}
+
+// layerSpool sends the layers of a save stream in the image's layer order, keeping the layers which arrive
+// before their turn in files of a temporary directory
+type layerSpool struct {
+	// order is the diff ids of the image's layers, a layer may be listed more than once
+	order []string
+	// next is the index in order of the next layer to send
+	next    int
+	workDir string
+	dir     string
+	// files maps the diff ids of the spooled layers to their files
+	files map[string]string
+}
+
+// stream reads the save stream r, sending its layers in order on layers
+func (s *layerSpool) stream(ctx context.Context, r io.Reader, layers chan<- models.LayerResult) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			// layers shared by several archive directories are symlinked to the first one
+			continue
+		}
+		dir, file := path.Split(header.Name)
+		switch {
+		case file == "layer.tar":
+			// legacy archive directories are named by v1 ids, the diff id is the digest of the layer tar
+			if err = s.spool("", tr); err != nil {
+				return err
+			}
+		case path.Clean(dir) == "blobs/sha256":
+			// blobs are layers, configs and manifests, the layers are uncompressed tars named by their diff id
+			blob := bufio.NewReader(tr)
+			diffID := "sha256:" + file
+			if !utils.IsTar(blob) || !s.pending(diffID, s.next) {
+				continue
+			}
+			if s.order[s.next] == diffID && !s.pending(diffID, s.next+1) {
+				if err = utils.SendLayer(ctx, layers, diffID, blob); err != nil {
+					return err
+				}
+				s.next++
+			} else if err = s.spool(diffID, blob); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if err = s.flush(ctx, layers); err != nil {
+			return err
+		}
+	}
+	if s.next < len(s.order) {
+		return fmt.Errorf("layer %s of the image is missing from the save stream", s.order[s.next])
+	}
+	return nil
+}
+
+// pending reports whether the layer still has to be sent at or after the index from of the layer order
+func (s *layerSpool) pending(diffID string, from int) bool {
+	for _, id := range s.order[from:] {
+		if id == diffID {
+			return true
+		}
+	}
+	return false
+}
+
+// spool writes the layer to a file of the spool directory. An empty diffID is computed from the layer's contents,
+// the file is dropped when the layer doesn't have to be sent anymore.
+func (s *layerSpool) spool(diffID string, r io.Reader) error {
+	if s.dir == "" {
+		dir, err := ioutil.TempDir(s.workDir, "vessel-stream-")
+		if err != nil {
+			return fmt.Errorf("failed to create layer spool directory: %v", err)
+		}
+		s.dir = dir
+	}
+	f, err := ioutil.TempFile(s.dir, "layer-")
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to spool layer: %v", err)
+	}
+	if diffID == "" {
+		diffID = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	}
+	if _, ok := s.files[diffID]; ok || !s.pending(diffID, s.next) {
+		os.Remove(f.Name())
+		return nil
+	}
+	s.files[diffID] = f.Name()
+	return nil
+}
+
+// flush sends the spooled layers which are next in order
+func (s *layerSpool) flush(ctx context.Context, layers chan<- models.LayerResult) error {
+	for s.next < len(s.order) {
+		diffID := s.order[s.next]
+		name, ok := s.files[diffID]
+		if !ok {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = utils.SendLayer(ctx, layers, diffID, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		s.next++
+		if !s.pending(diffID, s.next) {
+			os.Remove(name)
+			delete(s.files, diffID)
+		}
+	}
+	return nil
+}
+
+// Close removes the spool directory
+func (s *layerSpool) Close() error {
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/deepfence/vessel/models"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// layerTar returns an uncompressed layer tar holding a single file with the content
+func layerTar(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	tw.Close()
+	return buf.Bytes()
+}
+
+func diffID(layer []byte) string {
+	sum := sha256.Sum256(layer)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// saveTar returns a save stream of the files, written in the order given
+func saveTar(t *testing.T, files ...interface{}) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		name, data := files[i].(string), files[i+1].([]byte)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// streamLayers runs the spool over the save stream, returning the digests and contents sent
+func streamLayers(t *testing.T, order []string, save []byte) ([]string, [][]byte, error) {
+	spool := &layerSpool{order: order, workDir: t.TempDir(), files: map[string]string{}}
+	defer spool.Close()
+	layers := make(chan models.LayerResult)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- spool.stream(context.Background(), bytes.NewReader(save), layers)
+		close(layers)
+	}()
+	var digests []string
+	var contents [][]byte
+	for layer := range layers {
+		data, err := ioutil.ReadAll(layer.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, layer.Digest)
+		contents = append(contents, data)
+	}
+	return digests, contents, <-errc
+}
+
+func TestLayerSpoolOrder(t *testing.T) {
+	base, app, config := layerTar(t, "base"), layerTar(t, "app"), []byte(`{"rootfs":{}}`)
+	baseID, appID := diffID(base), diffID(app)
+	tests := []struct {
+		name  string
+		order []string
+		save  []byte
+		want  [][]byte
+	}{
+		{
+			name:  "oci layout in order",
+			order: []string{baseID, appID},
+			save:  saveTar(t, "blobs/sha256/"+baseID[7:], base, "blobs/sha256/"+appID[7:], app),
+			want:  [][]byte{base, app},
+		},
+		{
+			name:  "oci layout out of order",
+			order: []string{baseID, appID},
+			save:  saveTar(t, "blobs/sha256/"+appID[7:], app, "blobs/sha256/cfg", config, "blobs/sha256/"+baseID[7:], base),
+			want:  [][]byte{base, app},
+		},
+		{
+			name:  "legacy save named by v1 ids",
+			order: []string{baseID, appID},
+			save:  saveTar(t, "f00d/layer.tar", app, "beef/layer.tar", base, "manifest.json", []byte("[]")),
+			want:  [][]byte{base, app},
+		},
+		{
+			name:  "layer listed twice",
+			order: []string{baseID, appID, baseID},
+			save:  saveTar(t, "blobs/sha256/"+baseID[7:], base, "blobs/sha256/"+appID[7:], app),
+			want:  [][]byte{base, app, base},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digests, contents, err := streamLayers(t, tt.order, tt.save)
+			if err != nil {
+				t.Fatalf("stream() error = %v", err)
+			}
+			if !reflect.DeepEqual(digests, tt.order) {
+				t.Errorf("digests = %v, want %v", digests, tt.order)
+			}
+			if !reflect.DeepEqual(contents, tt.want) {
+				t.Errorf("layers sent out of order")
+			}
+		})
+	}
+}
+
+func TestLayerSpoolMissingLayer(t *testing.T) {
+	base := layerTar(t, "base")
+	order := []string{diffID(base), diffID(layerTar(t, "missing"))}
+	digests, _, err := streamLayers(t, order, saveTar(t, "blobs/sha256/"+diffID(base)[7:], base))
+	if err == nil {
+		t.Fatal("stream() of a save stream missing a layer succeeded")
+	}
+	if len(digests) != 1 {
+		t.Errorf("sent %d layers, want the 1 present", len(digests))
+	}
+}
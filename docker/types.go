@@ -1,5 +1,37 @@
 package docker
 
+import (
+	"github.com/deepfence/vessel/models"
+	"github.com/docker/docker/client"
+	"io"
+)
+
 type Docker struct {
 	socketPath string
+	// workDir is where images are spooled to for streaming and opening layers, the default temp dir when empty
+	workDir string
+}
+
+// changeKinds maps the docker api change kinds to vessel change kinds
+var changeKinds = map[uint8]models.ChangeKind{
+	0: models.ChangeModified,
+	1: models.ChangeAdded,
+	2: models.ChangeDeleted,
+}
+
+// logStream is a container log stream closing the docker client along with the stream
+type logStream struct {
+	io.ReadCloser
+	// logs is the raw log stream when ReadCloser demultiplexes it
+	logs   io.ReadCloser
+	client *client.Client
+}
+
+func (s *logStream) Close() error {
+	err := s.ReadCloser.Close()
+	if s.logs != nil {
+		s.logs.Close()
+	}
+	s.client.Close()
+	return err
 }
@@ -0,0 +1,79 @@
+package vessel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// dockerConfigFile is the subset of ~/.docker/config.json needed to find the current context
+type dockerConfigFile struct {
+	CurrentContext string `json:"currentContext"`
+}
+
+// dockerContextMeta is the subset of a docker context's meta.json needed to find its endpoint
+type dockerContextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host string `json:"Host"`
+	} `json:"Endpoints"`
+}
+
+// dockerConfigDir returns the docker cli config directory, honouring DOCKER_CONFIG
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// currentDockerContextEndpoint returns the docker endpoint of the docker context selected with
+// `docker context use` or DOCKER_CONTEXT. An empty endpoint is returned when no context config
+// exists or the default context is in use, so callers fall back to the default socket.
+func currentDockerContextEndpoint() (string, error) {
+	configDir, err := dockerConfigDir()
+	if err != nil {
+		return "", err
+	}
+	contextName := os.Getenv("DOCKER_CONTEXT")
+	if contextName == "" {
+		data, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		var config dockerConfigFile
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", err
+		}
+		contextName = config.CurrentContext
+	}
+	if contextName == "" || contextName == "default" {
+		return "", nil
+	}
+
+	// docker stores context metadata in a directory named after the sha256 of the context name
+	digest := sha256.Sum256([]byte(contextName))
+	metaPath := filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(digest[:]), "meta.json")
+	data, err := ioutil.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var meta dockerContextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", err
+	}
+	return meta.Endpoints["docker"].Host, nil
+}
@@ -0,0 +1,89 @@
+package vessel
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"strings"
+)
+
+// containerdSnapshotterDriverType is the driver-type docker reports in its driver status when images are
+// stored by containerd snapshotters rather than by a graph driver
+const containerdSnapshotterDriverType = "io.containerd.snapshotter.v1"
+
+// DockerInfo describes where a docker daemon keeps its data, for scanning image layers directly on disk
+type DockerInfo struct {
+	// DockerRootDir is the data-root as reported by the daemon, /var/lib/docker by default
+	DockerRootDir string `json:"dockerRootDir"`
+	// DataRoot is DockerRootDir with its symlinks resolved, when it is a path on this host
+	DataRoot string `json:"dataRoot"`
+	// StorageDriver is the graph driver of the layers, like overlay2, btrfs or zfs
+	StorageDriver string `json:"storageDriver"`
+	// ContainerdImageStore is set when the daemon keeps its images in containerd's moby namespace, through
+	// the containerd image store of docker 24 and later, the storage driver is then a containerd snapshotter
+	ContainerdImageStore bool `json:"containerdImageStore"`
+	// Rootless is set for a daemon running rootless, in a user namespace without host networking, whose
+	// container pids and paths have to be entered through the daemon's namespaces
+	Rootless bool `json:"rootless"`
+}
+
+// GetDockerInfo returns the data-root, storage driver, image store and rootless mode of the docker daemon at
+// sockPath from its /info endpoint
+func GetDockerInfo(sockPath string) (*DockerInfo, error) {
+	info, err := queryDockerInfo(sockPath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	dataRoot := info.DockerRootDir
+	// the data-root is often a symlink to a larger disk, the daemon reports it unresolved
+	if resolved, err := filepath.EvalSymlinks(dataRoot); err == nil {
+		dataRoot = resolved
+	}
+	return &DockerInfo{
+		DockerRootDir:        info.DockerRootDir,
+		DataRoot:             dataRoot,
+		StorageDriver:        info.Driver,
+		ContainerdImageStore: usesContainerdImageStore(info),
+		Rootless:             isRootless(info),
+	}, nil
+}
+
+// isRootless reports whether the docker daemon runs rootless, from the name=rootless entry of its security options
+func isRootless(info types.Info) bool {
+	for _, opt := range info.SecurityOptions {
+		if strings.Contains(opt, "rootless") {
+			return true
+		}
+	}
+	return false
+}
+
+// usesContainerdImageStore reports whether the docker daemon stores its images with containerd,
+// from the driver-type of its driver status
+func usesContainerdImageStore(info types.Info) bool {
+	for _, status := range info.DriverStatus {
+		if status[0] == "driver-type" && status[1] == containerdSnapshotterDriverType {
+			return true
+		}
+	}
+	return false
+}
+
+// queryDockerInfo returns the /info response of the docker daemon at sockPath
+func queryDockerInfo(sockPath string, opts Options) (types.Info, error) {
+	ep, err := resolveEndpoint(sockPath, opts)
+	if err != nil {
+		return types.Info{}, err
+	}
+	dockerCli, err := newDockerClient(ep)
+	if err != nil {
+		return types.Info{}, errors.Wrapf(err, " :error creating docker client")
+	}
+	defer dockerCli.Close()
+	info, err := dockerCli.Info(context.Background())
+	if err != nil {
+		return types.Info{}, errors.Wrapf(err, " :error querying docker info")
+	}
+	return info, nil
+}
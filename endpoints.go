@@ -0,0 +1,303 @@
+package vessel
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/deepfence/vessel/constants"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runtimeEndpoint is a parsed runtime endpoint along with how to connect to it
+type runtimeEndpoint struct {
+	url      string
+	protocol string
+	addr     string
+	dial     func(ctx context.Context, addr string) (net.Conn, error)
+	// tlsConfig is set for tcp endpoints using TLS, nil for plaintext
+	tlsConfig *tls.Config
+	// timeout bounds connecting to and querying the endpoint
+	timeout time.Duration
+	// namespace is the containerd namespace probed for containers
+	namespace string
+	// conn is an existing containerd connection used instead of dialing, it is never closed by vessel
+	conn *grpc.ClientConn
+}
+
+// containerdNamespace returns the containerd namespace of the endpoint, k8s.io unless set
+func (ep *runtimeEndpoint) containerdNamespace() string {
+	if ep.namespace != "" {
+		return ep.namespace
+	}
+	return constants.CONTAINERD_K8S_NS
+}
+
+// Endpoint is a runtime endpoint to probe during detection
+type Endpoint struct {
+	URL     string
+	Runtime string
+	// Timeout bounds connecting to and querying the endpoint. When zero the timeout query parameter
+	// of the URL is used, like unix:///run/containerd/containerd.sock?timeout=2s, or else Options.Timeout.
+	// Remote tcp endpoints usually need a longer timeout than local unix sockets.
+	Timeout time.Duration
+	// Namespace is the containerd namespace probed for containers, Options.Namespace when empty
+	Namespace string
+	// Conn is an existing grpc connection to the containerd daemon at URL, for agents which already keep one.
+	// It is used instead of dialing URL and vessel never closes it.
+	Conn *grpc.ClientConn
+}
+
+// EndpointsFromMap converts an endpoint to runtime map, like constants.SupportedRuntimes,
+// to endpoints using the default timeout
+func EndpointsFromMap(endPoints map[string]string) []Endpoint {
+	converted := make([]Endpoint, 0, len(endPoints))
+	for endPoint, runtime := range endPoints {
+		converted = append(converted, Endpoint{URL: endPoint, Runtime: runtime})
+	}
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].URL < converted[j].URL
+	})
+	return converted
+}
+
+// resolve parses the endpoint like resolveEndpoint, applying the endpoint's timeout
+func (e Endpoint) resolve(opts Options) (*runtimeEndpoint, error) {
+	ep, err := resolveEndpoint(e.URL, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e.Timeout > 0 {
+		ep.timeout = e.Timeout
+	}
+	if e.Namespace != "" {
+		ep.namespace = e.Namespace
+	}
+	ep.conn = e.Conn
+	return ep, nil
+}
+
+// dialDocker is the docker client dial function, it connects to the endpoint address
+// regardless of the address the http transport asks for and wraps the connection in TLS when enabled
+func (ep *runtimeEndpoint) dialDocker(ctx context.Context, _, _ string) (net.Conn, error) {
+	conn, err := ep.dial(ctx, ep.addr)
+	if err != nil || ep.tlsConfig == nil {
+		return conn, err
+	}
+	tlsConfig := ep.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = tlsServerName(ep.addr)
+	}
+	return tls.Client(conn, tlsConfig), nil
+}
+
+// tlsServerName returns the host name of an endpoint address the server certificate is verified against,
+// the address with or without a port, like registry.local:2376, [::1]:2376 or docker.example.com
+func tlsServerName(addr string) string {
+	u, err := url.Parse("//" + addr)
+	if err != nil {
+		return addr
+	}
+	return u.Hostname()
+}
+
+// resolveSocketPath resolves the symlinks of a socket path, like /var/run/docker.sock on distros where /var/run
+// links to /run or a socket linked from a runtime specific directory. The path is returned as is when it can't be
+// resolved, so a missing socket is still reported by its configured path.
+func resolveSocketPath(socketPath string) string {
+	resolved, err := filepath.EvalSymlinks(socketPath)
+	if err != nil {
+		return socketPath
+	}
+	return resolved
+}
+
+// checkSocket fails right away for unix endpoints whose socket file doesn't exist,
+// rather than leaving the grpc dialer to retry until the timeout
+func (ep *runtimeEndpoint) checkSocket() error {
+	if ep.protocol != constants.UnixProtocol {
+		return nil
+	}
+	info, err := os.Stat(ep.addr)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to endpoint '%s'", ep.url)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("could not connect to endpoint '%s': not a unix socket", ep.url)
+	}
+	return nil
+}
+
+// candidateEndpoints returns the groups of endpoints to probe in order, detection stops at
+// the first group with a detected runtime
+func candidateEndpoints(opts Options) [][]Endpoint {
+	var groups [][]Endpoint
+	if len(opts.Endpoints) > 0 {
+		groups = append(groups, opts.Endpoints)
+	}
+	if opts.UseDockerContext {
+		endPoint, err := currentDockerContextEndpoint()
+		if err != nil {
+			opts.logger().Warn(errors.Wrap(err, "could not resolve current docker context"))
+		}
+		if endPoint != "" {
+			groups = append(groups, []Endpoint{{URL: endPoint, Runtime: constants.DOCKER}})
+		}
+	}
+	if opts.UseDockerContainerd {
+		groups = append(groups, []Endpoint{{
+			URL:       constants.DockerContainerdSocket,
+			Runtime:   constants.CONTAINERD,
+			Namespace: constants.CONTAINERD_MOBY_NS,
+		}})
+	}
+	nested := runningInContainer()
+	if nested && opts.PreferNestedDocker {
+		groups = append(groups, EndpointsFromMap(constants.NestedDockerRuntimes))
+	}
+	groups = append(groups, defaultEndpoints())
+	if nested && !opts.PreferNestedDocker {
+		groups = append(groups, EndpointsFromMap(constants.NestedDockerRuntimes))
+	}
+	if opts.PreferredRuntime != "" {
+		groups = preferRuntime(groups, opts.PreferredRuntime.String())
+	}
+	return groups
+}
+
+// preferRuntime moves the endpoints of the preferred runtime out of the groups into a leading group,
+// so they are probed first and not probed again when falling back to the other runtimes
+func preferRuntime(groups [][]Endpoint, preferred string) [][]Endpoint {
+	var preferredGroup []Endpoint
+	var otherGroups [][]Endpoint
+	for _, group := range groups {
+		var others []Endpoint
+		for _, endPoint := range group {
+			if endPoint.Runtime == preferred {
+				preferredGroup = append(preferredGroup, endPoint)
+			} else {
+				others = append(others, endPoint)
+			}
+		}
+		if len(others) > 0 {
+			otherGroups = append(otherGroups, others)
+		}
+	}
+	if len(preferredGroup) == 0 {
+		return otherGroups
+	}
+	return append([][]Endpoint{preferredGroup}, otherGroups...)
+}
+
+// runningInContainer reports whether vessel itself runs inside a container,
+// like a docker-in-docker or sysbox build container
+func runningInContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultEndpoints returns the endpoints probed by AutoDetectRuntime in order: the system sockets of
+// constants.SupportedRuntimes, the existing sockets matching the glob endpoints, then the sockets of the
+// running user, the home directory based ones, which include Docker Desktop's sockets on macOS, and the
+// rootless ones of its runtime directory. A system runtime is thus detected ahead of a developer setup.
+// Each group is sorted by URL.
+func defaultEndpoints() []Endpoint {
+	endPoints := EndpointsFromMap(constants.SupportedRuntimes)
+	endPoints = append(endPoints, EndpointsFromMap(globEndpoints("/"))...)
+	if home := userHomeDir(); home != "" {
+		homeEndpoints := make(map[string]string, len(constants.HomeRuntimes)+len(constants.DockerDesktopRuntimes))
+		for relPath, runtime := range constants.HomeRuntimes {
+			homeEndpoints[constants.UnixProtocol+"://"+filepath.Join(home, relPath)] = runtime
+		}
+		for relPath, runtime := range constants.DockerDesktopRuntimes {
+			homeEndpoints[constants.UnixProtocol+"://"+filepath.Join(home, relPath)] = runtime
+		}
+		endPoints = append(endPoints, EndpointsFromMap(homeEndpoints)...)
+	}
+	return append(endPoints, userRuntimeDirEndpoints()...)
+}
+
+// globEndpoints returns the endpoints of the existing sockets matching constants.GlobRuntimes
+// in the filesystem tree at root, which is / but for tests
+func globEndpoints(root string) map[string]string {
+	endPoints := make(map[string]string)
+	for pattern, runtime := range constants.GlobRuntimes {
+		// a bad pattern is a programming error in the constants, matches are best effort
+		matches, _ := filepath.Glob(filepath.Join(root, pattern))
+		for _, match := range matches {
+			rel, err := filepath.Rel(root, match)
+			if err != nil {
+				continue
+			}
+			endPoint := constants.UnixProtocol + ":///" + filepath.ToSlash(rel)
+			// docker's own containerd keeps its containers in the moby namespace, it is only
+			// probed with UseDockerContainerd
+			if endPoint != constants.DockerContainerdSocket {
+				endPoints[endPoint] = runtime
+			}
+		}
+	}
+	return endPoints
+}
+
+// userRuntimeDirEndpoints returns the endpoints of the rootless runtimes of the running user, containerd's
+// probed in the default namespace rather than k8s.io
+func userRuntimeDirEndpoints() []Endpoint {
+	runtimeDir := userRuntimeDir()
+	if runtimeDir == "" {
+		return nil
+	}
+	var endPoints []Endpoint
+	for relPath, runtime := range constants.UserRuntimeDirRuntimes {
+		endPoint := Endpoint{URL: constants.UnixProtocol + "://" + filepath.Join(runtimeDir, relPath), Runtime: runtime}
+		if runtime == constants.CONTAINERD {
+			endPoint.Namespace = constants.CONTAINERD_DEFAULT_NS
+		}
+		endPoints = append(endPoints, endPoint)
+	}
+	sort.Slice(endPoints, func(i, j int) bool {
+		return endPoints[i].URL < endPoints[j].URL
+	})
+	return endPoints
+}
+
+// userRuntimeDir returns the runtime directory of a non root user, where rootless runtimes
+// keep their sockets: $XDG_RUNTIME_DIR, falling back to /run/user/$UID
+func userRuntimeDir() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return runtimeDir
+	}
+	uid := os.Getuid()
+	if uid <= 0 {
+		return ""
+	}
+	return filepath.Join("/run/user", strconv.Itoa(uid))
+}
+
+// userHomeDir returns the home directory of the running user, or an empty string when it
+// can't be determined or is the filesystem root
+func userHomeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		current, err := user.Current()
+		if err != nil {
+			return ""
+		}
+		home = current.HomeDir
+	}
+	if home == "" || home == "/" {
+		return ""
+	}
+	return home
+}
@@ -0,0 +1,24 @@
+//go:build darwin
+// +build darwin
+
+package vessel
+
+import (
+	"github.com/deepfence/vessel/constants"
+	"testing"
+)
+
+func TestDefaultEndpointsDockerDesktop(t *testing.T) {
+	home := t.TempDir()
+	setenv(t, "HOME", home)
+	endPoint := "unix://" + home + "/.docker/run/docker.sock"
+	for _, candidate := range defaultEndpoints() {
+		if candidate.URL == endPoint {
+			if candidate.Runtime != constants.DOCKER {
+				t.Errorf("Docker Desktop's socket %s is probed as %s, want docker", endPoint, candidate.Runtime)
+			}
+			return
+		}
+	}
+	t.Errorf("Docker Desktop's socket %s isn't probed", endPoint)
+}
@@ -0,0 +1,112 @@
+package vessel
+
+import (
+	"github.com/deepfence/vessel/constants"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTLSServerName(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"docker.example.com:2376", "docker.example.com"},
+		{"docker.example.com", "docker.example.com"},
+		{"10.0.0.5:443", "10.0.0.5"},
+		{"[::1]:2376", "::1"},
+		{"[::1]", "::1"},
+	}
+	for _, tt := range tests {
+		if got := tlsServerName(tt.addr); got != tt.want {
+			t.Errorf("tlsServerName(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// setenv sets an environment variable for the test, unsetting it when value is empty
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestUserRuntimeDirEndpoints(t *testing.T) {
+	setenv(t, "XDG_RUNTIME_DIR", "/run/user/1000")
+	want := []Endpoint{{
+		URL:       "unix:///run/user/1000/containerd/containerd.sock",
+		Runtime:   constants.CONTAINERD,
+		Namespace: constants.CONTAINERD_DEFAULT_NS,
+	}}
+	if got := userRuntimeDirEndpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("userRuntimeDirEndpoints() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUserRuntimeDirFallback(t *testing.T) {
+	setenv(t, "XDG_RUNTIME_DIR", "")
+	want := "/run/user/" + strconv.Itoa(os.Getuid())
+	if os.Getuid() <= 0 {
+		// root has no user runtime directory, rootless runtimes don't run as root
+		want = ""
+	}
+	if got := userRuntimeDir(); got != want {
+		t.Errorf("userRuntimeDir() = %q, want %q", got, want)
+	}
+}
+
+func TestGlobEndpoints(t *testing.T) {
+	root := t.TempDir()
+	for _, socket := range []string{"run/k3s/containerd/containerd.sock", "run/docker/containerd/containerd.sock"} {
+		path := filepath.Join(root, socket)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	endPoints := globEndpoints(root)
+	if runtime := endPoints["unix:///run/k3s/containerd/containerd.sock"]; runtime != constants.CONTAINERD {
+		t.Errorf("k3s containerd socket = %q, want it probed as containerd, got %v", runtime, endPoints)
+	}
+	if _, ok := endPoints[constants.DockerContainerdSocket]; ok {
+		t.Errorf("docker's containerd socket is probed, got %v", endPoints)
+	}
+}
+
+func TestDefaultEndpointsOrder(t *testing.T) {
+	// user paths which sort before the system sockets
+	setenv(t, "HOME", "/Users/dev")
+	setenv(t, "XDG_RUNTIME_DIR", "/home/dev/.run")
+	endPoints := defaultEndpoints()
+	system := len(constants.SupportedRuntimes)
+	if len(endPoints) < system {
+		t.Fatalf("defaultEndpoints() = %+v, want the system sockets", endPoints)
+	}
+	for i, candidate := range endPoints {
+		_, isSystem := constants.SupportedRuntimes[candidate.URL]
+		if isSystem != (i < system) {
+			t.Errorf("endpoint %d is %s, want the %d system sockets first", i, candidate.URL, system)
+		}
+	}
+	last := endPoints[len(endPoints)-1]
+	if last.URL != "unix:///home/dev/.run/containerd/containerd.sock" {
+		t.Errorf("last endpoint is %s, want the rootless containerd socket", last.URL)
+	}
+}
@@ -0,0 +1,139 @@
+package vessel
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/deepfence/vessel/containerd"
+	"github.com/deepfence/vessel/tarball"
+	"github.com/deepfence/vessel/utils"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrEmptyScheme is returned when an endpoint has no scheme, like a bare socket path without unix://
+	ErrEmptyScheme = errors.New("endpoint has no scheme")
+	// ErrUnsupportedProtocol is returned when an endpoint uses a scheme vessel can't connect with
+	ErrUnsupportedProtocol = errors.New("protocol not supported")
+	// ErrContainerNotFound is returned when a container doesn't exist in the runtime
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrAmbiguousContainerID is returned when a short container id matches more than one container
+	ErrAmbiguousContainerID = errors.New("ambiguous container id")
+	// ErrImageNotFound is returned when no image matches an image id, or by the tarball runtime when the
+	// tarball holds no image with the name
+	ErrImageNotFound = tarball.ErrImageNotFound
+	// ErrAmbiguousImageID is returned when a short image id matches more than one image
+	ErrAmbiguousImageID = errors.New("ambiguous image id")
+	// ErrIncompatibleRuntime is returned when the runtime is older than a required version
+	ErrIncompatibleRuntime = errors.New("incompatible container runtime version")
+	// ErrPermissionDenied is returned when a runtime socket exists but the process may not connect to it
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrContainerdNotCompiled is returned for containerd operations in builds with the no_containerd tag
+	ErrContainerdNotCompiled = errors.New("containerd support is not compiled in, vessel was built with the no_containerd tag")
+	// ErrSSHNotCompiled is returned for ssh:// endpoints in builds with the no_ssh tag
+	ErrSSHNotCompiled = errors.New("ssh endpoint support is not compiled in, vessel was built with the no_ssh tag")
+	// ErrUnsupportedSnapshotter is returned when a containerd container's snapshotter, like devmapper or
+	// stargz, can't be mounted read-only for scanning, see GetSnapshotter for the snapshotter of a namespace
+	ErrUnsupportedSnapshotter = containerd.ErrUnsupportedSnapshotter
+	// ErrLogsNotSupported is returned for the logs of a containerd container whose task writes to fifos,
+	// like a ctr run without --log-uri, rather than to a log file vessel can read
+	ErrLogsNotSupported = containerd.ErrLogsNotSupported
+	// ErrExtractLimitExceeded is returned when an image holds more files or bytes than the MaxFiles and
+	// MaxBytes extraction options allow
+	ErrExtractLimitExceeded = utils.ErrExtractLimitExceeded
+	// ErrCheckpointNotSupported is returned when the node can't checkpoint containers,
+	// because CRIU isn't installed or docker's experimental features are disabled
+	ErrCheckpointNotSupported = errors.New("container checkpoints are not supported or enabled on the node")
+)
+
+// endpointError keeps the human readable message of an endpoint failure while
+// letting callers match the failure mode with errors.Is
+type endpointError struct {
+	msg string
+	err error
+}
+
+func (e *endpointError) Error() string {
+	return e.msg
+}
+
+func (e *endpointError) Unwrap() error {
+	return e.err
+}
+
+// UnsupportedProtocolError is returned for an endpoint with an unsupported scheme,
+// it matches ErrUnsupportedProtocol with errors.Is
+type UnsupportedProtocolError struct {
+	Scheme string
+}
+
+func (e *UnsupportedProtocolError) Error() string {
+	return fmt.Sprintf("protocol %q not supported", e.Scheme)
+}
+
+func (e *UnsupportedProtocolError) Is(target error) bool {
+	return target == ErrUnsupportedProtocol
+}
+
+// containerError translates the runtime specific not found errors for a container into ErrContainerNotFound
+func containerError(containerID string, err error) error {
+	if client.IsErrNotFound(err) || errdefs.IsNotFound(err) {
+		return errors.Wrapf(ErrContainerNotFound, "container %s", containerID)
+	}
+	return err
+}
+
+// PermissionDeniedError is returned when the process lacks permission to connect to a runtime socket,
+// it matches ErrPermissionDenied with errors.Is
+type PermissionDeniedError struct {
+	SocketPath string
+	Err        error
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("permission denied connecting to %s, add the user to the socket's group (e.g. docker) or run privileged: %v",
+		e.SocketPath, e.Err)
+}
+
+func (e *PermissionDeniedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PermissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// permissionError turns a permission failure connecting to socketPath into a PermissionDeniedError
+func permissionError(socketPath string, err error) error {
+	if errors.Is(err, os.ErrPermission) || strings.Contains(err.Error(), "permission denied") {
+		return &PermissionDeniedError{SocketPath: socketPath, Err: err}
+	}
+	return err
+}
+
+// isEndpointAbsent reports whether err only means nothing is listening on the endpoint,
+// like a missing socket file or a refused connection
+func isEndpointAbsent(err error) bool {
+	if errors.Is(err, ErrPermissionDenied) {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) {
+		return true
+	}
+	// grpc flattens dial errors into strings
+	msg := err.Error()
+	return strings.Contains(msg, "no such file or directory") || strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "not a unix socket")
+}
+
+// checkpointError maps a failure to checkpoint a container because CRIU is unavailable to ErrCheckpointNotSupported
+func checkpointError(containerID string, err error) error {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "criu") || strings.Contains(msg, "experimental") {
+		return errors.Wrapf(ErrCheckpointNotSupported, "checkpoint of container %s failed: %v", containerID, err)
+	}
+	return containerError(containerID, err)
+}
@@ -0,0 +1,234 @@
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/docker"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// GetImageLayers returns the ordered layer diff ids of the image, as listed in its rootfs config
+func GetImageLayers(runtime, sockPath, imageRef, namespace string) ([]string, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetImageLayers(imageRef, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).GetImageLayers(imageRef, namespace)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetImageLabels returns the labels of the image config, an empty map when the image has none.
+// For containerd the image is looked up in the k8s.io namespace, or moby on docker's own containerd.
+func GetImageLabels(runtime, sockPath, imageName string) (map[string]string, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetImageLabels(imageName)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).GetImageLabels(imageName)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetImageDigest returns the registry digest of the image, like sha256:..., for matching scan results against
+// registries. It differs from the local image id returned by the runtimes' GetImageID, the digest of the image config.
+// For docker it is taken from the repo digests, for containerd it is the digest of the image's target descriptor.
+func GetImageDigest(runtime, sockPath, imageRef, namespace string) (string, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetImageDigest(imageRef, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).GetImageDigest(imageRef, namespace)
+	}
+	return "", fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// GetImageHistory returns the build steps of the image oldest first, with the created-by command and the diff id
+// of the layer each step created, for attributing packages to layers without extracting the image
+func GetImageHistory(runtime, sockPath, imageRef, namespace string) ([]models.HistoryEntry, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).GetImageHistory(imageRef, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).GetImageHistory(imageRef, namespace)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// ExtractImageStream streams the uncompressed layer tars of the image one at a time as they are read, for
+// pipelining layers into a scanner without extracting the whole image first. Only one layer is in flight,
+// so each Reader has to be read to its end or closed before the next layer is sent, and the channel is closed
+// after the last layer, after the result carrying the error of a failed stream, or once ctx is done.
+// Layers are sent in the image's layer order and Digest is the layer's diff id for both runtimes. containerd
+// reads the layers from its content store, docker's save stream is parsed as it arrives, spooling the layers
+// the archive holds ahead of their turn.
+func ExtractImageStream(ctx context.Context, runtime, sockPath, imageName, namespace string) (<-chan models.LayerResult, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).ExtractImageStream(ctx, imageName, namespace)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).ExtractImageStream(ctx, imageName, namespace)
+	}
+	return nil, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// ExtractImageFilesystem flattens all layers of the image into a single tar at outputTarPath, for scanning an
+// image rather than a live container. For docker a throwaway container is created from the image and exported,
+// for containerd a view of the image's committed snapshot is mounted read-only and archived, unpacking the image
+// first when needed. The tar is removed when the extraction fails.
+func ExtractImageFilesystem(runtime, sockPath, imageRef, namespace, outputTarPath string) error {
+	return ExtractImageFilesystemWithOptions(runtime, sockPath, imageRef, namespace, outputTarPath, models.ExtractOptions{})
+}
+
+// ExtractImageFilesystemWithOptions is ExtractImageFilesystem failing with ErrExtractLimitExceeded once the
+// filesystem tar holds more files or bytes than the MaxFiles and MaxBytes limits of opts, its other options
+// don't apply
+func ExtractImageFilesystemWithOptions(runtime, sockPath, imageRef, namespace, outputTarPath string, opts models.ExtractOptions) error {
+	var export func(w io.Writer) error
+	switch runtime {
+	case constants.DOCKER:
+		export = func(w io.Writer) error {
+			return docker.NewWithSocket(sockPath).ExportImageFilesystem(imageRef, namespace, w)
+		}
+	case constants.CONTAINERD:
+		export = func(w io.Writer) error {
+			return newContainerd(sockPath).ExportImageFilesystem(imageRef, namespace, w)
+		}
+	default:
+		return fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	out, err := os.Create(outputTarPath)
+	if err != nil {
+		return err
+	}
+	if err = exportWithLimits(export, out, opts); err != nil {
+		out.Close()
+		os.Remove(outputTarPath)
+		return errors.Wrapf(err, " :error extracting filesystem of image %s", imageRef)
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(outputTarPath)
+		return err
+	}
+	return nil
+}
+
+// exportWithLimits calls export to write to w, through a pipe enforcing the file and byte limits of opts when set
+func exportWithLimits(export func(w io.Writer) error, w io.Writer, opts models.ExtractOptions) error {
+	if opts.MaxFiles == 0 && opts.MaxBytes == 0 {
+		return export(w)
+	}
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		err := utils.CopyFilesystemTarWithLimits(w, pr, opts.MaxFiles, opts.MaxBytes)
+		// stops the export once the limits are exceeded
+		pr.CloseWithError(err)
+		copied <- err
+	}()
+	err := export(pw)
+	pw.CloseWithError(err)
+	if copyErr := <-copied; copyErr != nil {
+		return copyErr
+	}
+	return err
+}
+
+// ExtractFlattenedFS extracts the image and merges its layers into a single rootfs tar at outputTarPath, for
+// scanners which don't attribute files to layers. The overlay whiteouts are applied, so files deleted by an upper
+// layer and the lower contents of opaque directories are left out.
+func ExtractFlattenedFS(runtime, sockPath, imageName, outputTarPath string) error {
+	return ExtractFlattenedFSWithOptions(runtime, sockPath, imageName, outputTarPath, models.ExtractOptions{})
+}
+
+// ExtractFlattenedFSWithOptions is ExtractFlattenedFS with the options of the runtimes' ExtractImageWithOptions,
+// the MaxFiles and MaxBytes limits apply to both the extracted image and the flattened tar
+func ExtractFlattenedFSWithOptions(runtime, sockPath, imageName, outputTarPath string, opts models.ExtractOptions) error {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).ExtractFlattenedFSWithOptions(imageName, outputTarPath, opts)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).ExtractFlattenedFSWithOptions(imageName, outputTarPath, opts)
+	}
+	return fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// OpenLayer opens the uncompressed tar of a layer of the image for random access, for scanners reading files at
+// known offsets rather than streaming the whole layer. layerDigest is a diff id, as reported by ExtractImageStream.
+// The returned reader has to be closed once done. containerd reads uncompressed layer blobs from its content
+// store and decompresses compressed ones to a temporary file, docker copies the layer tar from the image's save
+// stream to a temporary file. An error is returned when the image has no layer with the diff id.
+func OpenLayer(runtime, sockPath, imageName, layerDigest string) (models.LayerReader, int64, error) {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).OpenLayer(imageName, layerDigest)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).OpenLayer(imageName, layerDigest)
+	}
+	return nil, 0, fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// PullImage pulls the image through the runtime at sockPath so it can be extracted and scanned,
+// for containerd the image is pulled into the given namespace
+func PullImage(runtime, sockPath, imageRef, namespace string, opts models.PullOptions) error {
+	switch runtime {
+	case constants.DOCKER:
+		return docker.NewWithSocket(sockPath).PullImage(imageRef, namespace, opts)
+	case constants.CONTAINERD:
+		return newContainerd(sockPath).PullImage(imageRef, namespace, opts)
+	}
+	return fmt.Errorf("unsupported container runtime %q", runtime)
+}
+
+// ResolveImageID resolves a short image id, like the 12 characters shown by docker images, to the full
+// id of the image it is a prefix of. ErrImageNotFound is returned when no image matches and
+// ErrAmbiguousImageID when several do.
+func ResolveImageID(runtime, sockPath, shortID, namespace string) (string, error) {
+	shortID = strings.TrimPrefix(shortID, "sha256:")
+	if shortID == "" {
+		return "", errors.New("image id is not set")
+	}
+	var imageList []models.ImageInfo
+	var err error
+	switch runtime {
+	case constants.DOCKER:
+		imageList, err = docker.NewWithSocket(sockPath).ListImages(namespace, models.ListImagesOptions{})
+	case constants.CONTAINERD:
+		imageList, err = newContainerd(sockPath).ListImages(namespace, models.ListImagesOptions{})
+	default:
+		return "", fmt.Errorf("unsupported container runtime %q", runtime)
+	}
+	if err != nil {
+		return "", err
+	}
+	return matchImageID(imageList, shortID)
+}
+
+// matchImageID returns the id of the image whose id, without its sha256: prefix, starts with shortID,
+// an exact match wins over longer ids sharing the prefix
+func matchImageID(imageList []models.ImageInfo, shortID string) (string, error) {
+	var matches []string
+	for _, image := range imageList {
+		id := strings.TrimPrefix(image.ID, "sha256:")
+		if id == shortID {
+			return image.ID, nil
+		}
+		if strings.HasPrefix(id, shortID) {
+			matches = append(matches, image.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.Wrapf(ErrImageNotFound, "image %s", shortID)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", errors.Wrapf(ErrAmbiguousImageID, "%s matches %s", shortID, strings.Join(matches, ", "))
+	}
+}
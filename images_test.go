@@ -0,0 +1,43 @@
+package vessel
+
+import (
+	"errors"
+	"github.com/deepfence/vessel/models"
+	"testing"
+)
+
+func TestMatchImageID(t *testing.T) {
+	imageList := []models.ImageInfo{
+		{ID: "sha256:4cdc5dd7eaad2f3c4d6c8cb0ca5a8cce3e0e0bd9c1ae0ab34b7c7b59a1d7a2f0", RepoTags: []string{"nginx:latest"}},
+		{ID: "sha256:4cdc9a8d66f1e3d3c1b0e2b6a9f8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0", RepoTags: []string{"redis:6"}},
+		{ID: "sha256:8f2d7a1c1b3e5d7f9a0b2c4d6e8f0a1b3c5d7e9f1a2b4c6d8e0f2a3b5c7d9e1f", RepoTags: []string{"alpine:3.14"}},
+	}
+	tests := []struct {
+		name    string
+		shortID string
+		want    string
+		wantErr error
+	}{
+		{name: "unique prefix", shortID: "8f2d7a1c1b3e", want: imageList[2].ID},
+		{name: "full id", shortID: "4cdc5dd7eaad2f3c4d6c8cb0ca5a8cce3e0e0bd9c1ae0ab34b7c7b59a1d7a2f0", want: imageList[0].ID},
+		{name: "ambiguous prefix", shortID: "4cdc", wantErr: ErrAmbiguousImageID},
+		{name: "no match", shortID: "deadbeef", wantErr: ErrImageNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchImageID(imageList, tt.shortID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("matchImageID(%s) error = %v, want %v", tt.shortID, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("matchImageID(%s) error = %v", tt.shortID, err)
+			}
+			if got != tt.want {
+				t.Errorf("matchImageID(%s) = %s, want %s", tt.shortID, got, tt.want)
+			}
+		})
+	}
+}
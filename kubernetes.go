@@ -0,0 +1,60 @@
+package vessel
+
+import (
+	"context"
+	"github.com/deepfence/vessel/constants"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// IsKubernetesNode reports whether the node is a kubernetes node rather than a standalone runtime:
+// a CRI socket is present, or the detected runtime runs kubelet managed containers
+func IsKubernetesNode() (bool, error) {
+	if criSocketExists() {
+		return true, nil
+	}
+	runtime, sockPath, err := AutoDetectRuntime()
+	if err != nil {
+		return false, err
+	}
+	return isKubernetesRuntime(runtime, sockPath, Options{})
+}
+
+// criSocketExists reports whether one of the well-known kubelet CRI sockets exists
+func criSocketExists() bool {
+	for _, socket := range constants.CRISockets {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isKubernetesRuntime reports whether the runtime at sockPath runs kubelet managed containers,
+// in the k8s.io namespace for containerd or labelled by dockershim for docker
+func isKubernetesRuntime(runtime, sockPath string, opts Options) (bool, error) {
+	ep, err := resolveEndpoint(sockPath, opts)
+	if err != nil {
+		return false, err
+	}
+	if runtime == constants.DOCKER {
+		dockerCli, err := newDockerClient(ep)
+		if err != nil {
+			return false, errors.Wrapf(err, " :error creating docker client")
+		}
+		defer dockerCli.Close()
+		containers, err := dockerCli.ContainerList(context.Background(), types.ContainerListOptions{
+			All:     true,
+			Limit:   1,
+			Filters: filters.NewArgs(filters.Arg("label", constants.KubernetesPodNameLabel)),
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, " :error listing docker containers")
+		}
+		return len(containers) > 0, nil
+	}
+
+	return hasContainerdKubernetesContainers(ep, opts)
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"io"
+	"time"
+)
+
+// ChangeKind is the kind of change made to a file in a container's writable layer
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// ChangedFile is a file changed in a container relative to its image
+type ChangedFile struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+}
+
+// PullOptions configures how images are pulled from a registry
+type PullOptions struct {
+	// InsecureSkipTLSVerify disables verification of the registry's TLS certificate
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+	// RegistryCAFile is a PEM bundle of additional CAs trusted for the registry
+	RegistryCAFile string `json:"registryCAFile,omitempty"`
+	// PlainHTTP talks to the registry over plain http instead of https
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+	// Auth holds the registry credentials
+	Auth AuthConfig `json:"auth,omitempty"`
+}
+
+// AuthConfig holds the credentials for a registry. When neither a username, password nor identity token
+// is set, the credentials are looked up in the docker config.json at ConfigFile, by default
+// $DOCKER_CONFIG/config.json or ~/.docker/config.json, and the registry is accessed anonymously if none is found
+type AuthConfig struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"-"`
+	// IdentityToken is an oauth refresh token used instead of a username and password
+	IdentityToken string `json:"-"`
+	ConfigFile    string `json:"configFile,omitempty"`
+}
+
+// Event is a container lifecycle event normalized across runtimes.
+// Action uses the docker event vocabulary: create, start, die, pause, unpause, destroy
+type Event struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ProgressFunc is called as image bytes are extracted, layer is the image archive entry being extracted
+// and bytesTotal is 0 when the total size isn't known
+type ProgressFunc func(bytesDone, bytesTotal int64, layer string)
+
+// Normalized container states returned across runtimes
+const (
+	ContainerRunning = "running"
+	ContainerPaused  = "paused"
+	ContainerExited  = "exited"
+	ContainerCreated = "created"
+)
+
+// Compression is the compression applied to a saved image tarball
+type Compression int
+
+const (
+	// CompressionNone writes the raw image tar
+	CompressionNone Compression = iota
+	// CompressionGzip writes a gzip compressed image tar, with a .gz extension
+	CompressionGzip
+	// CompressionZstd writes a zstd compressed image tar, with a .zst extension
+	CompressionZstd
+)
+
+// ExtractOptions configures image extraction
+type ExtractOptions struct {
+	// Progress is called as the image tarball is extracted, when set
+	Progress ProgressFunc
+	// VerifyDigests checks the extracted layers against their digests
+	VerifyDigests bool
+	// WorkDir is where intermediate files are written, like the docker archive containerd and OCI layout images are
+	// converted through, for nodes whose temp dir is a small tmpfs. The files go to a new directory of WorkDir,
+	// removed once the extraction returns, and need about twice the image size. Next to the extraction path when
+	// empty. Docker images are streamed into the extraction path without intermediate files.
+	WorkDir string
+	// MaxFiles aborts the extraction with utils.ErrExtractLimitExceeded once the image layers hold more files,
+	// unlimited when 0. Setting it along with MaxBytes is recommended for scanning untrusted images, which can
+	// be crafted to exhaust the disk when their layers are flattened.
+	MaxFiles int
+	// MaxBytes aborts the extraction once the decompressed contents of the image layers exceed it, unlimited when 0
+	MaxBytes int64
+}
+
+// ImageInfo describes an image stored by the runtime
+type ImageInfo struct {
+	ID       string    `json:"id"`
+	RepoTags []string  `json:"repoTags"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// ImageMetadata describes an image along with the platform and runtime settings of its config
+type ImageMetadata struct {
+	ImageInfo
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	User         string   `json:"user,omitempty"`
+	Env          []string `json:"env,omitempty"`
+	Entrypoint   []string `json:"entrypoint,omitempty"`
+	Cmd          []string `json:"cmd,omitempty"`
+	WorkingDir   string   `json:"workingDir,omitempty"`
+	// Labels are the labels of the image config, empty when it has none
+	Labels map[string]string `json:"labels"`
+	// DiffIDs are the diff ids of the image's layers, base layer first
+	DiffIDs []string `json:"diffIDs"`
+}
+
+// ListImagesOptions filters the images returned by ListImages
+type ListImagesOptions struct {
+	// Repository only lists images of the repository, like nginx or docker.io/library/nginx, when set
+	Repository string
+	// Pattern only lists the images with a repo tag matching the glob, like myregistry.com/* or nginx:1.*,
+	// matched against the familiar name as docker's reference filter does
+	Pattern string
+	// Regexp only lists the images with a repo tag matching the regular expression, matched against the
+	// fully qualified name like docker.io/library/nginx:latest
+	Regexp string
+}
+
+// ListContainersOptions filters the containers returned by ListContainers
+type ListContainersOptions struct {
+	// LabelSelector only lists the containers whose labels match, like scanned=true, see utils.ParseLabelSelector
+	LabelSelector string
+}
+
+// HistoryEntry is a step of an image build, in the order the image was built
+type HistoryEntry struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	// EmptyLayer is set for steps which only changed the image config, like ENV or CMD
+	EmptyLayer bool `json:"emptyLayer"`
+	// DiffID is the diff id of the layer the step created, empty for empty layers
+	DiffID string `json:"diffID,omitempty"`
+}
+
+// ImageRef is the image a container was created from
+type ImageRef struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+// TarOptions configures how a filesystem tree is archived
+type TarOptions struct {
+	// FollowSymlinks archives the file a symlink points to instead of the symlink. Symlinks are
+	// resolved within the archived tree, symlinks to directories and dangling ones are kept as symlinks.
+	FollowSymlinks bool `json:"followSymlinks,omitempty"`
+}
+
+// LogOptions selects the container logs returned by GetContainerLogs
+type LogOptions struct {
+	// Follow keeps the log stream open, returning new logs as they are written until it's closed
+	Follow bool `json:"follow,omitempty"`
+	// Since only returns logs written after the time, when set
+	Since time.Time `json:"since,omitempty"`
+	// Tail only returns the last lines of the logs, all lines when zero
+	Tail int `json:"tail,omitempty"`
+}
+
+// Mount types reported by GetContainerMounts
+const (
+	MountBind   = "bind"
+	MountVolume = "volume"
+	MountTmpfs  = "tmpfs"
+)
+
+// Mount is a volume, host directory or tmpfs mounted into a container
+type Mount struct {
+	// Source is the host path of the mount, for docker volumes the path of the volume data, empty for tmpfs
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Type is one of MountBind, MountVolume or MountTmpfs
+	Type string `json:"type"`
+	RW   bool   `json:"rw"`
+}
+
+// ContainerInfo is the metadata of a container normalized across runtimes
+type ContainerInfo struct {
+	ID string `json:"id"`
+	// Name is the container name, for containerd the kubernetes container name or else the id
+	Name string `json:"name"`
+	// Image is the image name the container was created from
+	Image   string    `json:"image"`
+	Created time.Time `json:"created"`
+	// StartedAt is when the container was last started, the zero time when it never was
+	StartedAt time.Time `json:"startedAt"`
+	// State is one of ContainerRunning, ContainerPaused, ContainerExited or ContainerCreated
+	State string `json:"state"`
+}
+
+// LayerReader is a layer opened for random access by OpenLayer. It has to be closed once done, closing it
+// releases the temporary file or content store reader the layer is read from.
+type LayerReader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// LayerResult is a layer of an image streamed by ExtractImageStream, or the error ending the stream
+type LayerResult struct {
+	// Digest identifies the layer, see ExtractImageStream for what it is for each runtime
+	Digest string
+	// Reader reads the uncompressed layer tar. It has to be read to its end or closed before the next
+	// layer is sent, closing it skips the rest of the layer.
+	Reader io.ReadCloser
+	// Err is set on the last result of a stream which failed, with no Reader
+	Err error
+}
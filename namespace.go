@@ -0,0 +1,86 @@
+//go:build !no_containerd
+// +build !no_containerd
+
+package vessel
+
+import (
+	"context"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/deepfence/vessel/constants"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// DetectContainerdNamespace returns the containerd namespace with the most containers on the daemon at socket,
+// rather than guessing between k8s.io and default. On a tie k8s.io wins on kubernetes nodes, where the
+// kubelet's CRI plugin keeps its containers there, and the namespaces are compared by name otherwise.
+func DetectContainerdNamespace(socket string) (string, error) {
+	ep, err := resolveEndpoint(socket, Options{})
+	if err != nil {
+		return "", err
+	}
+	clientd, err := newContainerdClient(context.Background(), ep, nil)
+	if err != nil {
+		return "", err
+	}
+	defer releaseContainerdClient(ep, clientd)
+	return busiestNamespace(context.Background(), clientd, ep.timeout, Options{}.logger())
+}
+
+// wellKnownNamespaces are checked first by busiestNamespace, so a check cut short by the deadline has most
+// likely counted the busiest namespace already
+var wellKnownNamespaces = map[string]bool{
+	constants.CONTAINERD_K8S_NS:  true,
+	constants.CONTAINERD_MOBY_NS: true,
+	namespaces.Default:           true,
+}
+
+// busiestNamespace returns the namespace of the containerd daemon with the most containers. Listing the
+// namespaces and counting their containers share one deadline of timeout, on nodes with many namespaces the
+// namespaces counted before the deadline are compared and the others are skipped, the well known ones first.
+func busiestNamespace(ctx context.Context, clientd *containerd.Client, timeout time.Duration, logger logrus.FieldLogger) (string, error) {
+	deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	names, err := clientd.NamespaceService().List(deadlineCtx)
+	if err != nil {
+		return "", errors.Wrapf(err, " :error listing containerd namespaces")
+	}
+	if len(names) == 0 {
+		return "", errors.New("containerd has no namespaces")
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return wellKnownNamespaces[names[i]] && !wellKnownNamespaces[names[j]]
+	})
+	counts := make(map[string]int, len(names))
+	var counted []string
+	for _, name := range names {
+		containers, err := clientd.Containers(namespaces.WithNamespace(deadlineCtx, name))
+		if err != nil && ctx.Err() == nil && deadlineCtx.Err() != nil {
+			logger.Warnf("containerd namespace detection timed out after %s, compared %d of %d namespaces",
+				timeout, len(counted), len(names))
+			break
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, " :error listing containers of containerd namespace %s", name)
+		}
+		counts[name] = len(containers)
+		counted = append(counted, name)
+	}
+	if len(counted) == 0 {
+		return "", errors.Errorf("containerd namespace detection timed out after %s, before any namespace was checked", timeout)
+	}
+	preferK8s := criSocketExists()
+	sort.Slice(counted, func(i, j int) bool {
+		if counts[counted[i]] != counts[counted[j]] {
+			return counts[counted[i]] > counts[counted[j]]
+		}
+		if preferK8s && (counted[i] == constants.CONTAINERD_K8S_NS) != (counted[j] == constants.CONTAINERD_K8S_NS) {
+			return counted[i] == constants.CONTAINERD_K8S_NS
+		}
+		return counted[i] < counted[j]
+	})
+	return counted[0], nil
+}
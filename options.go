@@ -0,0 +1,175 @@
+package vessel
+
+import (
+	"crypto/tls"
+	"github.com/deepfence/vessel/constants"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"net"
+	"time"
+)
+
+// DetectBy selects what counts as a detected container runtime
+type DetectBy int
+
+const (
+	// HasContainers requires the runtime to have containers, the default
+	HasContainers DetectBy = iota
+	// DaemonResponds requires the runtime daemon to answer an api call, even when it has no containers
+	DaemonResponds
+	// SocketReachable only requires a connection to the runtime endpoint to succeed
+	SocketReachable
+)
+
+// ProbeOutcome is the outcome of probing an endpoint during detection
+type ProbeOutcome int
+
+const (
+	// EndpointUnreachable means the endpoint couldn't be connected to or its runtime didn't respond
+	EndpointUnreachable ProbeOutcome = iota
+	// EndpointEmpty means the runtime is up but has no containers, so it isn't detected with HasContainers
+	EndpointEmpty
+	// EndpointDetected means the runtime counts as detected for the detection mode
+	EndpointDetected
+)
+
+// ProbeFunc is called with the outcome of each endpoint probed during detection,
+// err is set for unreachable endpoints
+type ProbeFunc func(endPoint, runtime string, outcome ProbeOutcome, err error)
+
+// MetricsRecorder receives detection metrics, for exporting them like as prometheus counters and a histogram.
+// Each probe of an endpoint with a listening runtime is an attempt, endpoints with nothing listening aren't
+// counted since most default endpoints are absent on any node.
+type MetricsRecorder interface {
+	// IncAttempt counts a probe of an endpoint
+	IncAttempt()
+	// IncResult counts the outcome of a probe, ok is whether the runtime was detected on the endpoint
+	IncResult(runtime string, ok bool)
+	// ObserveLatency records how long a probe took
+	ObserveLatency(d time.Duration)
+}
+
+// Options configures how container runtime endpoints are connected to during detection
+type Options struct {
+	// Dialer is used to connect to runtime sockets, a zero-value net.Dialer is used when nil
+	Dialer *net.Dialer
+	// Endpoints are probed before any other endpoint, each with its own timeout
+	Endpoints []Endpoint
+	// UseDockerContext probes the endpoint of the current docker context (~/.docker/contexts)
+	// before the default endpoints
+	UseDockerContext bool
+	// TLSConfig enables TLS on tcp endpoints, connections are plaintext when nil
+	TLSConfig *tls.Config
+	// InsecureSkipVerify enables TLS on tcp endpoints without verifying the server certificate,
+	// for daemons using self-signed certificates. Unlike plaintext the connection is still encrypted.
+	InsecureSkipVerify bool
+	// DetectBy selects what counts as a detected runtime, HasContainers when not set
+	DetectBy DetectBy
+	// DetectByRuntime overrides DetectBy for the runtimes it has an entry for, for example to require
+	// containers for docker but only a responding daemon for containerd on kubernetes nodes
+	DetectByRuntime map[RuntimeType]DetectBy
+	// GRPCDialOptions are appended to the default dial options of the containerd connection,
+	// for example to raise grpc.MaxCallRecvMsgSize on nodes with many containers
+	GRPCDialOptions []grpc.DialOption
+	// UseDockerContainerd probes the containerd instance managed by docker before the default endpoints,
+	// looking for docker's containers in its moby namespace. This gives containerd api access, like to
+	// snapshots, to the containers of docker hosts.
+	UseDockerContainerd bool
+	// PreferNestedDocker probes the docker daemon nested in a docker-in-docker or sysbox container
+	// before the default endpoints, which include a host socket mounted into the container
+	PreferNestedDocker bool
+	// PreferredRuntime probes the endpoints of this runtime first, detection falls back
+	// to the other runtimes only when none of them is detected
+	PreferredRuntime RuntimeType
+	// SSHKeyPath is the private key used for ssh:// endpoints, the ssh agent and the
+	// keys of the ssh client configuration are used when empty. ssh endpoints are reached by running
+	// the OpenSSH ssh binary, which must be on PATH, with BatchMode so it never prompts. The remote
+	// host must have the docker cli, 18.09 or later, for `docker system dial-stdio`.
+	SSHKeyPath string
+	// OnProbe is called with the outcome of each probed endpoint, for diagnostics like telling
+	// a user that docker is up but has no containers
+	OnProbe ProbeFunc
+	// Timeout bounds connecting to and querying the endpoints which don't set their own timeout,
+	// constants.Timeout when zero
+	Timeout time.Duration
+	// Namespace is the containerd namespace probed for containers on the endpoints which don't
+	// set their own namespace, k8s.io when empty
+	Namespace string
+	// DetectNamespace probes the containerd namespace with the most containers on the containerd endpoints
+	// which don't set a namespace, instead of Namespace or k8s.io, see DetectContainerdNamespace. Counting the
+	// containers of every namespace is bounded by the endpoint timeout, on nodes with many namespaces the busiest
+	// of the namespaces counted in time wins.
+	DetectNamespace bool
+	// LabelSelector only counts the containers whose labels match towards detection with HasContainers,
+	// like scanned=true, see utils.ParseLabelSelector
+	LabelSelector string
+	// Logger receives the detection logs, the standard logrus logger is used when nil
+	Logger logrus.FieldLogger
+	// Metrics receives the probe metrics of detection, when set
+	Metrics MetricsRecorder
+}
+
+// netDialer returns the configured dialer, falling back to the zero-value dialer
+func (o Options) netDialer() *net.Dialer {
+	if o.Dialer != nil {
+		return o.Dialer
+	}
+	return &net.Dialer{}
+}
+
+// timeout returns the timeout of the endpoints which don't set their own, falling back to constants.Timeout
+func (o Options) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return constants.Timeout
+}
+
+// logger returns the configured logger, falling back to the standard logrus logger
+func (o Options) logger() logrus.FieldLogger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return logrus.StandardLogger()
+}
+
+// detectBy returns the detection mode of the runtime
+func (o Options) detectBy(runtime string) DetectBy {
+	if detectBy, ok := o.DetectByRuntime[RuntimeType(runtime)]; ok {
+		return detectBy
+	}
+	return o.DetectBy
+}
+
+// reportProbe passes the outcome of probing an endpoint to OnProbe when set
+func (o Options) reportProbe(endPoint, runtime string, outcome ProbeOutcome, err error) {
+	if o.OnProbe != nil {
+		o.OnProbe(endPoint, runtime, outcome, err)
+	}
+}
+
+// recordProbe passes the outcome and latency of probing an endpoint to Metrics when set,
+// unless nothing is listening on the endpoint
+func (o Options) recordProbe(runtime string, detected bool, latency time.Duration, err error) {
+	if o.Metrics == nil || (err != nil && isEndpointAbsent(err)) {
+		return
+	}
+	o.Metrics.IncAttempt()
+	o.Metrics.IncResult(runtime, detected)
+	o.Metrics.ObserveLatency(latency)
+}
+
+// tlsConfig returns the TLS config for tcp endpoints, nil when connections are plaintext
+func (o Options) tlsConfig() *tls.Config {
+	if o.TLSConfig == nil && !o.InsecureSkipVerify {
+		return nil
+	}
+	tlsConfig := &tls.Config{}
+	if o.TLSConfig != nil {
+		tlsConfig = o.TLSConfig.Clone()
+	}
+	if o.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig
+}
@@ -0,0 +1,33 @@
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"github.com/deepfence/vessel/constants"
+	"github.com/pkg/errors"
+)
+
+// Ping checks that the runtime at sockPath is answering, for readiness and liveness checks which don't need the
+// full detection. Docker is pinged on its /_ping endpoint and containerd through its version service, so no
+// namespace is assumed and no containers are listed, unlike detection which checks the runtime has containers.
+func Ping(ctx context.Context, runtime, sockPath string) error {
+	ep, err := resolveEndpoint(sockPath, Options{})
+	if err != nil {
+		return err
+	}
+	switch runtime {
+	case constants.DOCKER:
+		dockerCli, err := newDockerClient(ep)
+		if err != nil {
+			return errors.Wrapf(err, " :error creating docker client")
+		}
+		defer dockerCli.Close()
+		if _, err = dockerCli.Ping(ctx); err != nil {
+			return errors.Wrapf(err, " :error pinging docker")
+		}
+		return nil
+	case constants.CONTAINERD:
+		return pingContainerd(ctx, ep, Options{})
+	}
+	return fmt.Errorf("unsupported container runtime %q", runtime)
+}
@@ -1,9 +1,64 @@
 package vessel
 
+import (
+	"fmt"
+	"github.com/deepfence/vessel/constants"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/tarball"
+	"strings"
+)
+
 // Runtime interface, interfaces all the container runtime methods
 type Runtime interface {
 	ExtractImage(imageID string, imageName string, path string) error
+	ExtractImageWithProgress(imageID, imageName, path string, progress models.ProgressFunc) error
+	ExtractImageWithOptions(imageID, imageName, path string, opts models.ExtractOptions) error
+	ExtractFlattenedFS(imageName, outputTarPath string) error
+	ExtractFlattenedFSWithOptions(imageName, outputTarPath string, opts models.ExtractOptions) error
 	GetImageID(imageName string) ([]byte, error)
+	GetImageIDs(names []string) (map[string]string, error)
+	ListImages(namespace string, opts models.ListImagesOptions) ([]models.ImageInfo, error)
 	Save(imageName, outputParam string) ([]byte, error)
+	SaveCompressed(imageName, outputPath string, compression models.Compression) (string, error)
 	GetSocket() string
+	GetContainerDiff(containerID, namespace string) ([]models.ChangedFile, error)
+	GetContainerImage(containerID, namespace string) (models.ImageRef, error)
+	PauseContainer(containerID, namespace string) error
+	UnpauseContainer(containerID, namespace string) error
+	PullImage(imageRef, namespace string, opts models.PullOptions) error
+}
+
+// NewTarballRuntime returns a Runtime reading the saved docker-archive or OCI layout image tarball at path,
+// for extracting images without a running daemon. Runtime detection doesn't apply to it and the
+// container and pull methods return errors. The runtime is a *tarball.Tarball, whose GetImageMetadata reads
+// the image config without extracting the layers.
+func NewTarballRuntime(path string) Runtime {
+	return tarball.New(path)
+}
+
+// RuntimeType identifies a container runtime, its values match the constants package runtime names
+type RuntimeType string
+
+const (
+	RuntimeDocker     RuntimeType = constants.DOCKER
+	RuntimeContainerd RuntimeType = constants.CONTAINERD
+	RuntimeCRIO       RuntimeType = constants.CRIO
+)
+
+// String returns the runtime name as used by the constants package
+func (t RuntimeType) String() string {
+	return string(t)
+}
+
+// ParseRuntimeType converts a runtime name like constants.DOCKER into a RuntimeType
+func ParseRuntimeType(s string) (RuntimeType, error) {
+	switch RuntimeType(strings.ToLower(strings.TrimSpace(s))) {
+	case RuntimeDocker:
+		return RuntimeDocker, nil
+	case RuntimeContainerd:
+		return RuntimeContainerd, nil
+	case RuntimeCRIO, "crio":
+		return RuntimeCRIO, nil
+	}
+	return "", fmt.Errorf("unknown container runtime %q", s)
 }
@@ -0,0 +1,168 @@
+//go:build !no_ssh
+// +build !no_ssh
+
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// sshAddr is the user@host address of an ssh endpoint
+type sshAddr string
+
+func (a sshAddr) Network() string {
+	return "ssh"
+}
+
+func (a sshAddr) String() string {
+	return string(a)
+}
+
+// sshConn is a connection to a remote docker daemon tunneled through the stdio of an ssh process,
+// the way the docker cli connects to ssh:// hosts
+type sshConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	remote sshAddr
+	// stop kills the ssh process
+	stop context.CancelFunc
+	// established is closed once the remote end has answered, the dial context no longer applies then
+	established chan struct{}
+	once        sync.Once
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if n > 0 {
+		c.once.Do(func() { close(c.established) })
+	}
+	return n, err
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *sshConn) Close() error {
+	c.stdin.Close()
+	c.stop()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *sshConn) LocalAddr() net.Addr {
+	return sshAddr("")
+}
+
+func (c *sshConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// the pipes of the ssh process are os.Files, which support deadlines on the platforms vessel runs on
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *sshConn) SetReadDeadline(t time.Time) error {
+	if f, ok := c.stdout.(*os.File); ok {
+		return f.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *sshConn) SetWriteDeadline(t time.Time) error {
+	if f, ok := c.stdin.(*os.File); ok {
+		return f.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// sshDialer returns a dialer connecting to the docker daemon of the remote host of an ssh://[user@]host[:port][/socket]
+// endpoint. The ssh client forwards the connection with `docker system dial-stdio` on the remote host, to the given
+// remote socket or the remote default docker socket. Authentication uses keyPath when set, otherwise the ssh agent.
+func sshDialer(endpoint, keyPath string) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in ssh endpoint %q", endpoint)
+	}
+	// never prompt for a password, detection must not block on a terminal
+	args := []string{"-o", "BatchMode=yes"}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	if keyPath != "" {
+		args = append(args, "-i", keyPath)
+	}
+	destination := u.Hostname()
+	if u.User != nil {
+		destination = u.User.Username() + "@" + destination
+	}
+	args = append(args, "--", destination, "docker")
+	if u.Path != "" && u.Path != "/" {
+		args = append(args, "-H", "unix://"+u.Path)
+	}
+	args = append(args, "system", "dial-stdio")
+
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// the process outlives the dial context once the remote end has answered, it is stopped when the
+		// connection is closed. An ssh session which doesn't come up before ctx is done, like for an
+		// unreachable host, is killed so reads fail rather than block.
+		procCtx, stop := context.WithCancel(context.Background())
+		cmd := exec.CommandContext(procCtx, "ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			stop()
+			return nil, fmt.Errorf("could not start ssh to %s: %v", destination, err)
+		}
+		conn := &sshConn{
+			cmd:         cmd,
+			stdin:       stdin,
+			stdout:      stdout,
+			remote:      sshAddr(destination),
+			stop:        stop,
+			established: make(chan struct{}),
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				// both are ready when the context ended after the remote end answered
+				select {
+				case <-conn.established:
+				default:
+					stop()
+				}
+			case <-conn.established:
+			case <-procCtx.Done():
+			}
+		}()
+		return conn, nil
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build no_ssh
+// +build no_ssh
+
+package vessel
+
+import (
+	"context"
+	"net"
+)
+
+func sshDialer(endpoint, keyPath string) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	return nil, ErrSSHNotCompiled
+}
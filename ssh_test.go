@@ -0,0 +1,77 @@
+//go:build !no_ssh
+// +build !no_ssh
+
+package vessel
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSSH puts an ssh executable running script first on PATH
+func fakeSSH(t *testing.T, script string) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+}
+
+func TestSSHDialerHonorsDialDeadline(t *testing.T) {
+	// an ssh session which never comes up, like for a host dropping packets
+	fakeSSH(t, "exec sleep 30")
+	dial, err := sshDialer("ssh://user@unreachable.example", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	conn, err := dial(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Error("Read() succeeded on a killed ssh session")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read() still blocked after the dial deadline")
+	}
+}
+
+func TestSSHDialerOutlivesDialContext(t *testing.T) {
+	// the remote end answers, like docker system dial-stdio relaying the daemon's response
+	fakeSSH(t, "echo pong; sleep 0.3; echo more; exec sleep 30")
+	dial, err := sshDialer("ssh://host.example", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	conn, err := dial(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	if _, err = conn.Read(buf); err != nil || string(buf) != "pong\n" {
+		t.Fatalf("Read() = %q, %v", buf, err)
+	}
+	cancel()
+	if _, err = conn.Read(buf); err != nil || string(buf[:5]) != "more\n" {
+		t.Errorf("Read() after the dial context ended = %q, %v, the ssh session was stopped", buf, err)
+	}
+}
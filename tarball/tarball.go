@@ -0,0 +1,516 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/deepfence/vessel/models"
+	"github.com/deepfence/vessel/utils"
+	"github.com/docker/distribution/reference"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxMetadataSize bounds the size of the tarball entries read into memory, manifests and configs are far smaller
+const maxMetadataSize = 4 << 20
+
+// ErrImageNotFound is returned when the tarball holds no image with the requested name or id
+var ErrImageNotFound = errors.New("image not found")
+
+// minIDPrefixLength is the shortest image id prefix matched, the length of the short ids docker shows
+const minIDPrefixLength = 12
+
+// errNotSupported is returned for the runtime operations that need a daemon
+var errNotSupported = errors.New("not supported by the tarball runtime, it has no daemon")
+
+// New instantiates a new Tarball runtime object reading the docker-archive or OCI layout tarball at path,
+// gzip or zstd compressed tarballs are supported too
+func New(path string) *Tarball {
+	return &Tarball{
+		path: path,
+	}
+}
+
+// GetSocket returns the path of the tarball, the tarball runtime has no socket
+func (t Tarball) GetSocket() string {
+	return t.path
+}
+
+// ExtractImage extracts the tarball into path, an OCI layout is migrated to the docker v1 layout using skopeo
+// like for containerd images. The tarball is extracted whole, imageName isn't used to select an image.
+func (t Tarball) ExtractImage(imageID, imageName, path string) error {
+	return t.ExtractImageWithOptions(imageID, imageName, path, models.ExtractOptions{})
+}
+
+// ExtractImageWithProgress is ExtractImage reporting progress as the tarball is read
+func (t Tarball) ExtractImageWithProgress(imageID, imageName, path string, progress models.ProgressFunc) error {
+	return t.ExtractImageWithOptions(imageID, imageName, path, models.ExtractOptions{Progress: progress})
+}
+
+// ExtractImageWithOptions is ExtractImage with progress reporting, digest verification, extraction limits and
+// a work dir for the docker archive an OCI layout is migrated through
+func (t Tarball) ExtractImageWithOptions(imageID, imageName, path string, opts models.ExtractOptions) error {
+	err := t.extract(path, opts)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(filepath.Join(path, "manifest.json")); os.IsNotExist(err) {
+		if err = utils.MigrateOCIToDockerV1InWorkDir(path, imageID, opts.WorkDir); err != nil {
+			return err
+		}
+	}
+	if opts.VerifyDigests {
+		return utils.VerifyImageDigests(path)
+	}
+	return nil
+}
+
+// ExtractFlattenedFS extracts the tarball and merges the layers of its image into a single filesystem tar at
+// outputTarPath with the whiteouts applied, see utils.FlattenLayers
+func (t Tarball) ExtractFlattenedFS(imageName, outputTarPath string) error {
+	return t.ExtractFlattenedFSWithOptions(imageName, outputTarPath, models.ExtractOptions{})
+}
+
+// ExtractFlattenedFSWithOptions is ExtractFlattenedFS with the options of ExtractImageWithOptions, the MaxFiles
+// and MaxBytes limits apply to both the extraction and the flattened tar
+func (t Tarball) ExtractFlattenedFSWithOptions(imageName, outputTarPath string, opts models.ExtractOptions) error {
+	imageID, err := t.GetImageID(imageName)
+	if err != nil {
+		return err
+	}
+	return utils.ExtractFlattened(outputTarPath, opts, func(dir string) error {
+		return t.ExtractImageWithOptions(strings.TrimSpace(string(imageID)), imageName, dir, opts)
+	})
+}
+
+// extract decompresses the tarball into tar, reporting progress on the compressed bytes read and
+// enforcing the limits on the decompressed tar when set
+func (t Tarball) extract(path string, opts models.ExtractOptions) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var source io.Reader = file
+	if opts.Progress != nil {
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			pipeWriter.CloseWithError(utils.CopyWithProgress(pipeWriter, file, info.Size(), opts.Progress))
+		}()
+		defer pipeReader.Close()
+		source = pipeReader
+	}
+	imageTar, err := compression.DecompressStream(source)
+	if err != nil {
+		return err
+	}
+	defer imageTar.Close()
+
+	var stderr bytes.Buffer
+	extract := exec.Command("tar", "xf", "-", "--warning=none", "-C"+path)
+	extract.Stderr = &stderr
+	if opts.MaxFiles == 0 && opts.MaxBytes == 0 {
+		extract.Stdin = imageTar
+		if err = extract.Run(); err != nil {
+			return errors.New(stderr.String())
+		}
+		return nil
+	}
+	pipe, err := extract.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = extract.Start(); err != nil {
+		return errors.New(stderr.String())
+	}
+	err = utils.CopyWithLimits(pipe, imageTar, 0, nil, opts.MaxFiles, opts.MaxBytes)
+	if err != nil {
+		pipe.Close()
+		extract.Wait()
+		return err
+	}
+	if err = pipe.Close(); err != nil {
+		return err
+	}
+	if err = extract.Wait(); err != nil {
+		return errors.New(stderr.String())
+	}
+	return nil
+}
+
+// GetImageID returns the id of the named image in the tarball, followed by a newline like for the other runtimes.
+// An empty name selects the image of a tarball holding a single image, ErrImageNotFound is returned when no
+// image matches.
+func (t Tarball) GetImageID(imageName string) ([]byte, error) {
+	image, err := t.image(imageName)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(image.id + "\n"), nil
+}
+
+// GetImageMetadata returns the id, tags, size and creation time of the named image in the tarball along with the
+// platform, runtime settings, labels and layer diff ids of its config, read without extracting the layers.
+// The image is selected like for GetImageID.
+func (t Tarball) GetImageMetadata(imageName string) (*models.ImageMetadata, error) {
+	image, err := t.image(imageName)
+	if err != nil {
+		return nil, err
+	}
+	labels := image.config.Config.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return &models.ImageMetadata{
+		ImageInfo: models.ImageInfo{
+			ID:       image.id,
+			RepoTags: image.repoTags,
+			Size:     image.size,
+			Created:  image.config.Created,
+		},
+		Architecture: image.config.Architecture,
+		OS:           image.config.OS,
+		User:         image.config.Config.User,
+		Env:          image.config.Config.Env,
+		Entrypoint:   image.config.Config.Entrypoint,
+		Cmd:          image.config.Config.Cmd,
+		WorkingDir:   image.config.Config.WorkingDir,
+		Labels:       labels,
+		DiffIDs:      image.config.RootFS.DiffIDs,
+	}, nil
+}
+
+// image returns the named image of the tarball, or its only image for an empty name
+func (t Tarball) image(imageName string) (archiveImage, error) {
+	archiveImages, err := t.images()
+	if err != nil {
+		return archiveImage{}, err
+	}
+	if imageName == "" && len(archiveImages) == 1 {
+		return archiveImages[0], nil
+	}
+	image, ok := findImage(archiveImages, imageName)
+	if !ok {
+		return archiveImage{}, fmt.Errorf("image %q in %s: %w", imageName, t.path, ErrImageNotFound)
+	}
+	return image, nil
+}
+
+// GetImageIDs returns the image ids of the named images in the tarball, names that don't resolve to an
+// image map to an empty id
+func (t Tarball) GetImageIDs(names []string) (map[string]string, error) {
+	archiveImages, err := t.images()
+	if err != nil {
+		return nil, err
+	}
+	imageIDs := make(map[string]string, len(names))
+	for _, name := range names {
+		imageIDs[name] = ""
+		if image, ok := findImage(archiveImages, name); ok {
+			imageIDs[name] = image.id
+		}
+	}
+	return imageIDs, nil
+}
+
+// ListImages returns the images of the tarball, the namespace isn't used
+func (t Tarball) ListImages(namespace string, opts models.ListImagesOptions) ([]models.ImageInfo, error) {
+	nameFilter, err := utils.NewImageNameFilter(opts.Pattern, opts.Regexp)
+	if err != nil {
+		return nil, err
+	}
+	var repository string
+	if opts.Repository != "" {
+		named, err := reference.ParseNormalizedNamed(opts.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository filter %q: %v", opts.Repository, err)
+		}
+		repository = named.Name()
+	}
+	archiveImages, err := t.images()
+	if err != nil {
+		return nil, err
+	}
+	var imageInfos []models.ImageInfo
+	for _, image := range archiveImages {
+		if repository != "" && !hasRepository(image, repository) {
+			continue
+		}
+		repoTags := append([]string{}, image.repoTags...)
+		if !nameFilter.IsEmpty() {
+			repoTags = repoTags[:0]
+			for _, tag := range image.repoTags {
+				if nameFilter.Matches(tag) {
+					repoTags = append(repoTags, tag)
+				}
+			}
+			if len(repoTags) == 0 {
+				continue
+			}
+		}
+		imageInfos = append(imageInfos, models.ImageInfo{
+			ID:       image.id,
+			RepoTags: repoTags,
+			Size:     image.size,
+			Created:  image.config.Created,
+		})
+	}
+	return imageInfos, nil
+}
+
+// Save copies the tarball to outputParam
+func (t Tarball) Save(imageName, outputParam string) ([]byte, error) {
+	return exec.Command("cp", t.path, outputParam).Output()
+}
+
+// SaveCompressed copies the tarball to outputPath with the given compression,
+// returning the output path with the compression's file extension
+func (t Tarball) SaveCompressed(imageName, outputPath string, compression models.Compression) (string, error) {
+	if compression == models.CompressionNone {
+		_, err := t.Save(imageName, outputPath)
+		return outputPath, err
+	}
+	outputPath = utils.CompressedOutputPath(outputPath, compression)
+	return outputPath, utils.SaveCompressed(exec.Command("cat", t.path), outputPath, compression)
+}
+
+// GetContainerDiff is not supported, a tarball has no containers
+func (t Tarball) GetContainerDiff(containerID, namespace string) ([]models.ChangedFile, error) {
+	return nil, fmt.Errorf("container diff is %v", errNotSupported)
+}
+
+// GetContainerImage is not supported, a tarball has no containers
+func (t Tarball) GetContainerImage(containerID, namespace string) (models.ImageRef, error) {
+	return models.ImageRef{}, fmt.Errorf("container image lookup is %v", errNotSupported)
+}
+
+// PauseContainer is not supported, a tarball has no containers
+func (t Tarball) PauseContainer(containerID, namespace string) error {
+	return fmt.Errorf("container pause is %v", errNotSupported)
+}
+
+// UnpauseContainer is not supported, a tarball has no containers
+func (t Tarball) UnpauseContainer(containerID, namespace string) error {
+	return fmt.Errorf("container unpause is %v", errNotSupported)
+}
+
+// PullImage is not supported, a tarball has no registry access
+func (t Tarball) PullImage(imageRef, namespace string, opts models.PullOptions) error {
+	return fmt.Errorf("image pull is %v", errNotSupported)
+}
+
+// images returns the images of the tarball, from the manifest.json of a docker-archive
+// or else from the index.json of an OCI layout
+func (t Tarball) images() ([]archiveImage, error) {
+	entries, sizes, err := t.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if manifestJSON, ok := entries["manifest.json"]; ok {
+		return archiveImages(manifestJSON, entries, sizes)
+	}
+	if indexJSON, ok := entries["index.json"]; ok {
+		return ociImages(indexJSON, entries)
+	}
+	return nil, fmt.Errorf("%s is neither a docker-archive nor an OCI layout tarball", t.path)
+}
+
+// readMetadata reads the small entries of the tarball into memory along with the size of every entry
+func (t Tarball) readMetadata() (map[string][]byte, map[string]int64, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	imageTar, err := compression.DecompressStream(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer imageTar.Close()
+
+	entries := make(map[string][]byte)
+	sizes := make(map[string]int64)
+	tr := tar.NewReader(imageTar)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", t.path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		sizes[name] = hdr.Size
+		if hdr.Size > maxMetadataSize {
+			continue
+		}
+		if entries[name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from %s: %v", name, t.path, err)
+		}
+	}
+	return entries, sizes, nil
+}
+
+func archiveImages(manifestJSON []byte, entries map[string][]byte, sizes map[string]int64) ([]archiveImage, error) {
+	var manifests []archiveManifest
+	if err := json.Unmarshal(manifestJSON, &manifests); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json: %v", err)
+	}
+	var archiveImages []archiveImage
+	for _, manifest := range manifests {
+		// docker names the config <hex>.json, or blobs/sha256/<hex> in an OCI compatible archive
+		image := archiveImage{
+			id:       "sha256:" + strings.TrimSuffix(path.Base(manifest.Config), ".json"),
+			repoTags: manifest.RepoTags,
+		}
+		for _, layer := range manifest.Layers {
+			image.size += sizes[path.Clean(layer)]
+		}
+		// a config that doesn't parse leaves the metadata empty rather than hiding the image
+		json.Unmarshal(entries[path.Clean(manifest.Config)], &image.config)
+		archiveImages = append(archiveImages, image)
+	}
+	return archiveImages, nil
+}
+
+func ociImages(indexJSON []byte, entries map[string][]byte) ([]archiveImage, error) {
+	var index ociIndex
+	if err := json.Unmarshal(indexJSON, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %v", err)
+	}
+	var archiveImages []archiveImage
+	for _, descriptor := range index.Manifests {
+		manifest, err := resolveManifest(descriptor, entries)
+		if err != nil {
+			return nil, err
+		}
+		image := archiveImage{id: manifest.Config.Digest}
+		for _, layer := range manifest.Layers {
+			image.size += layer.Size
+		}
+		if name := imageName(descriptor); name != "" {
+			image.repoTags = []string{name}
+		}
+		json.Unmarshal(entries[blobPath(manifest.Config.Digest)], &image.config)
+		archiveImages = append(archiveImages, image)
+	}
+	return archiveImages, nil
+}
+
+// resolveManifest reads the image manifest of the descriptor, taking the first manifest of a multi-platform index
+func resolveManifest(descriptor ociDescriptor, entries map[string][]byte) (ociManifest, error) {
+	for {
+		content, ok := entries[blobPath(descriptor.Digest)]
+		if !ok {
+			return ociManifest{}, fmt.Errorf("blob %s is missing from the tarball", descriptor.Digest)
+		}
+		if !strings.Contains(descriptor.MediaType, "index") && !strings.Contains(descriptor.MediaType, "manifest.list") {
+			var manifest ociManifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				return ociManifest{}, fmt.Errorf("failed to parse manifest %s: %v", descriptor.Digest, err)
+			}
+			return manifest, nil
+		}
+		var index ociIndex
+		if err := json.Unmarshal(content, &index); err != nil {
+			return ociManifest{}, fmt.Errorf("failed to parse index %s: %v", descriptor.Digest, err)
+		}
+		if len(index.Manifests) == 0 {
+			return ociManifest{}, fmt.Errorf("index %s has no manifests", descriptor.Digest)
+		}
+		descriptor = index.Manifests[0]
+	}
+}
+
+// imageName returns the image name annotated on an index.json entry, containerd records the full name
+// while the OCI annotation may only hold the tag
+func imageName(descriptor ociDescriptor) string {
+	if name := descriptor.Annotations["io.containerd.image.name"]; name != "" {
+		return name
+	}
+	return descriptor.Annotations["org.opencontainers.image.ref.name"]
+}
+
+// blobPath returns the path of the blob of an OCI layout
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// findImage returns the image with the name, id or id prefix imageName, matching short names like nginx
+// against their normalized form docker.io/library/nginx:latest. An id prefix has to be at least 12 hex
+// characters long, like docker's short ids, and match a single image.
+func findImage(archiveImages []archiveImage, imageName string) (archiveImage, bool) {
+	if imageName == "" {
+		return archiveImage{}, false
+	}
+	normalized := normalizeImageRef(imageName)
+	for _, image := range archiveImages {
+		if image.id == imageName || image.id == "sha256:"+imageName {
+			return image, true
+		}
+		for _, tag := range image.repoTags {
+			if tag == imageName || normalizeImageRef(tag) == normalized {
+				return image, true
+			}
+		}
+	}
+	prefix := strings.TrimPrefix(imageName, "sha256:")
+	if !isIDPrefix(prefix) {
+		return archiveImage{}, false
+	}
+	var match archiveImage
+	matches := 0
+	for _, image := range archiveImages {
+		if strings.HasPrefix(strings.TrimPrefix(image.id, "sha256:"), prefix) {
+			match = image
+			matches++
+		}
+	}
+	return match, matches == 1
+}
+
+// isIDPrefix reports whether s can be an image id prefix, at least minIDPrefixLength lower case hex characters
+func isIDPrefix(s string) bool {
+	if len(s) < minIDPrefixLength {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRepository reports whether one of the image tags is in the normalized repository
+func hasRepository(image archiveImage, repository string) bool {
+	for _, tag := range image.repoTags {
+		if named, err := reference.ParseNormalizedNamed(tag); err == nil && named.Name() == repository {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeImageRef(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.TagNameOnly(named).String()
+}
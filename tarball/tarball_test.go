@@ -0,0 +1,127 @@
+package tarball
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	nginxID = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	redisID = "sha256:1111111111112222222222222222222222222222222222222222222222222222"
+)
+
+// writeArchive writes a docker-archive tarball of the files to a temp dir, returning its path
+func writeArchive(t *testing.T, files map[string]string) string {
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func twoImageArchive(t *testing.T) string {
+	return writeArchive(t, map[string]string{
+		"manifest.json": `[{"Config":"` + nginxID[7:] + `.json","RepoTags":["nginx:1.21"],"Layers":["a/layer.tar"]},` +
+			`{"Config":"` + redisID[7:] + `.json","RepoTags":["redis:6"],"Layers":["b/layer.tar"]}]`,
+		nginxID[7:] + ".json": `{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/bin"],` +
+			`"Cmd":["nginx"],"Labels":{"maintainer":"nginx"}},"rootfs":{"diff_ids":["sha256:aaaa"]}}`,
+		redisID[7:] + ".json": `{"architecture":"arm64","os":"linux","rootfs":{"diff_ids":["sha256:bbbb"]}}`,
+		"a/layer.tar":         "",
+		"b/layer.tar":         "",
+	})
+}
+
+func TestGetImageID(t *testing.T) {
+	runtime := New(twoImageArchive(t))
+	tests := []struct {
+		name     string
+		want     string
+		notFound bool
+	}{
+		{name: "nginx:1.21", want: nginxID},
+		{name: "docker.io/library/redis:6", want: redisID},
+		{name: nginxID, want: nginxID},
+		{name: redisID[7:], want: redisID},
+		{name: "111111111111222", want: redisID},
+		{name: "sha256:11111111111122", want: redisID},
+		// shared by both images
+		{name: "111111111111", notFound: true},
+		// shorter than a short id
+		{name: "11111", notFound: true},
+		{name: "alpine", notFound: true},
+		// the tarball holds more than one image
+		{name: "", notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageID, err := runtime.GetImageID(tt.name)
+			if tt.notFound {
+				if !errors.Is(err, ErrImageNotFound) {
+					t.Errorf("GetImageID(%q) = %q, %v, want ErrImageNotFound", tt.name, imageID, err)
+				}
+				return
+			}
+			if err != nil || strings.TrimSpace(string(imageID)) != tt.want {
+				t.Errorf("GetImageID(%q) = %q, %v, want %s", tt.name, imageID, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageIDSingleImage(t *testing.T) {
+	runtime := New(writeArchive(t, map[string]string{
+		"manifest.json":       `[{"Config":"` + nginxID[7:] + `.json","RepoTags":["nginx:1.21"],"Layers":[]}]`,
+		nginxID[7:] + ".json": `{}`,
+	}))
+	if imageID, err := runtime.GetImageID(""); err != nil || strings.TrimSpace(string(imageID)) != nginxID {
+		t.Errorf("GetImageID(\"\") = %q, %v, want the only image %s", imageID, err, nginxID)
+	}
+	if _, err := runtime.GetImageID("alpine"); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("GetImageID(alpine) error = %v, want ErrImageNotFound for a name the only image doesn't have", err)
+	}
+}
+
+func TestGetImageMetadata(t *testing.T) {
+	runtime := New(twoImageArchive(t))
+	metadata, err := runtime.GetImageMetadata("nginx:1.21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ID != nginxID || metadata.Architecture != "amd64" || metadata.OS != "linux" {
+		t.Errorf("GetImageMetadata() = %+v", metadata)
+	}
+	if !reflect.DeepEqual(metadata.Cmd, []string{"nginx"}) || !reflect.DeepEqual(metadata.Env, []string{"PATH=/bin"}) {
+		t.Errorf("GetImageMetadata() cmd %v env %v", metadata.Cmd, metadata.Env)
+	}
+	if metadata.Labels["maintainer"] != "nginx" || !reflect.DeepEqual(metadata.DiffIDs, []string{"sha256:aaaa"}) {
+		t.Errorf("GetImageMetadata() labels %v diff ids %v", metadata.Labels, metadata.DiffIDs)
+	}
+
+	metadata, err = runtime.GetImageMetadata("redis:6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Labels == nil || len(metadata.Labels) != 0 {
+		t.Errorf("GetImageMetadata() labels = %v, want an empty map", metadata.Labels)
+	}
+	if _, err = runtime.GetImageMetadata("alpine"); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("GetImageMetadata(alpine) error = %v, want ErrImageNotFound", err)
+	}
+}
@@ -0,0 +1,60 @@
+package tarball
+
+import "time"
+
+// Tarball is a runtime backed by a saved image tarball instead of a daemon
+type Tarball struct {
+	path string
+}
+
+// archiveManifest is an entry of the manifest.json of a docker-archive tarball
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ociDescriptor describes a blob of an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociIndex is an OCI image index, the index.json of an OCI image layout or a multi-platform image
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI or docker v2 image manifest
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// imageConfig is the subset of an image config read by the tarball runtime
+type imageConfig struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       struct {
+		User       string            `json:"User"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// archiveImage is an image found in the tarball
+type archiveImage struct {
+	id       string
+	repoTags []string
+	size     int64
+	config   imageConfig
+}
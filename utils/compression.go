@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// compressions maps vessel compressions to the containerd archive compressions
+var compressions = map[models.Compression]compression.Compression{
+	models.CompressionNone: compression.Uncompressed,
+	models.CompressionGzip: compression.Gzip,
+	models.CompressionZstd: compression.Zstd,
+}
+
+// CompressedOutputPath returns outputPath with the file extension of the compression appended when missing
+func CompressedOutputPath(outputPath string, c models.Compression) string {
+	algorithm, ok := compressions[c]
+	if !ok {
+		return outputPath
+	}
+	extension := algorithm.Extension()
+	if extension == "" || strings.HasSuffix(outputPath, "."+extension) {
+		return outputPath
+	}
+	return outputPath + "." + extension
+}
+
+// SaveCompressed runs the save command, writing the image tar it prints to stdout to outputPath
+// compressed with c. The partial output file is removed when saving fails.
+func SaveCompressed(save *exec.Cmd, outputPath string, c models.Compression) error {
+	algorithm, ok := compressions[c]
+	if !ok {
+		return fmt.Errorf("unsupported compression %d", c)
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	err = saveCompressed(save, out, algorithm)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+func saveCompressed(save *exec.Cmd, out io.Writer, algorithm compression.Compression) error {
+	var stderr bytes.Buffer
+	save.Stderr = &stderr
+	imageTar, err := save.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	compressed, err := compression.CompressStream(out, algorithm)
+	if err != nil {
+		return err
+	}
+	err = save.Start()
+	if err != nil {
+		compressed.Close()
+		return err
+	}
+	_, err = io.Copy(compressed, imageTar)
+	if err != nil {
+		save.Wait()
+		compressed.Close()
+		return err
+	}
+	err = save.Wait()
+	if err != nil {
+		compressed.Close()
+		return errors.New(stderr.String())
+	}
+	return compressed.Close()
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/deepfence/vessel/models"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedOutputPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		compression models.Compression
+		want        string
+	}{
+		{"image.tar", models.CompressionNone, "image.tar"},
+		{"image.tar", models.CompressionGzip, "image.tar.gz"},
+		{"image.tar.gz", models.CompressionGzip, "image.tar.gz"},
+		{"image.tar", models.CompressionZstd, "image.tar.zst"},
+		{"image.tar.zst", models.CompressionZstd, "image.tar.zst"},
+		// the extension of another compression isn't the one of the compression
+		{"image.tar.gz", models.CompressionZstd, "image.tar.gz.zst"},
+	}
+	for _, tt := range tests {
+		if got := CompressedOutputPath(tt.path, tt.compression); got != tt.want {
+			t.Errorf("CompressedOutputPath(%s, %d) = %s, want %s", tt.path, tt.compression, got, tt.want)
+		}
+	}
+}
+
+func TestSaveCompressed(t *testing.T) {
+	dir := t.TempDir()
+	imageTar := fsTar(t, "etc/os-release", "ID=alpine", "bin/sh", "elf")
+	tarPath := filepath.Join(dir, "image.tar")
+	if err := ioutil.WriteFile(tarPath, imageTar, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []models.Compression{models.CompressionNone, models.CompressionGzip, models.CompressionZstd} {
+		outputPath := CompressedOutputPath(filepath.Join(dir, "saved.tar"), c)
+		// cat stands in for docker save and nerdctl save, printing the image tar to stdout
+		if err := SaveCompressed(exec.Command("cat", tarPath), outputPath, c); err != nil {
+			t.Fatalf("SaveCompressed(%d) error = %v", c, err)
+		}
+		out, err := os.Open(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := compression.DecompressStream(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decompressed.GetCompression() != compressions[c] {
+			t.Errorf("SaveCompressed(%d) wrote compression %v, want %v", c, decompressed.GetCompression(), compressions[c])
+		}
+		got, err := ioutil.ReadAll(decompressed)
+		decompressed.Close()
+		out.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, imageTar) {
+			t.Errorf("SaveCompressed(%d) round trip doesn't match the image tar", c)
+		}
+	}
+}
+
+func TestSaveCompressedRemovesOutputOnFailure(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "saved.tar.gz")
+	save := exec.Command("sh", "-c", "echo no such image >&2; exit 1")
+	if err := SaveCompressed(save, outputPath, models.CompressionGzip); err == nil || err.Error() != "no such image\n" {
+		t.Fatalf("SaveCompressed() error = %v, want the stderr of the save", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("the output of a failed save was left behind: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// whiteoutPrefix marks the deletion of the file it prefixes from the lower layers
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaqueDir marks its directory as opaque, hiding the contents of the directory in the lower layers
+	whiteoutOpaqueDir = ".wh..wh..opq"
+)
+
+// layerIndex maps the paths of a layer to the index of their last entry in the layer tar
+type layerIndex map[string]int
+
+// FlattenLayers merges the layer tars, base layer first, into a single filesystem tar written to w, applying
+// the overlay whiteouts: a .wh.<name> entry deletes name from the lower layers and a .wh..wh..opq entry hides
+// the lower contents of its directory. A file in an upper layer replaces the file of a lower one, a file
+// replacing a directory hides the directory's contents. Whiteout entries aren't written. Layers are read twice,
+// once from the top to find the surviving entries and once from the base to write them, so hardlinks are
+// written after their targets. Compressed layers are decompressed.
+func FlattenLayers(layerPaths []string, w io.Writer) error {
+	return FlattenLayersWithLimits(layerPaths, w, 0, 0)
+}
+
+// FlattenLayersWithLimits is FlattenLayers failing with ErrExtractLimitExceeded once the entries of the layers
+// exceed maxFiles or their bytes exceed maxBytes, 0 is unlimited. The entries are accounted as the layers are
+// first read, before anything is written to w.
+func FlattenLayersWithLimits(layerPaths []string, w io.Writer, maxFiles int, maxBytes int64) error {
+	limiter := &extractLimiter{maxFiles: maxFiles, maxBytes: maxBytes}
+	// entries seen in upper layers, mapped to whether they are directories
+	seen := map[string]bool{}
+	deleted := map[string]bool{}
+	opaque := map[string]bool{}
+	survivors := make([]layerIndex, len(layerPaths))
+	for i := len(layerPaths) - 1; i >= 0; i-- {
+		index := layerIndex{}
+		layerDeleted, layerOpaque := map[string]bool{}, map[string]bool{}
+		layerSeen := map[string]bool{}
+		err := readLayer(layerPaths[i], func(n int, hdr *tar.Header, _ io.Reader) error {
+			if err := limiter.add(layerPaths[i]+":"+hdr.Name, hdr.Size); err != nil {
+				return err
+			}
+			name := cleanEntryName(hdr.Name)
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+			switch {
+			case base == whiteoutOpaqueDir:
+				layerOpaque[dir] = true
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				layerDeleted[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+				return nil
+			}
+			if name == "" || isHidden(name, seen, deleted, opaque) {
+				return nil
+			}
+			index[name] = n
+			layerSeen[name] = hdr.Typeflag == tar.TypeDir
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", layerPaths[i], err)
+		}
+		// the whiteouts and entries of a layer only apply to the layers below it
+		for name, isDir := range layerSeen {
+			seen[name] = isDir
+		}
+		for name := range layerDeleted {
+			deleted[name] = true
+		}
+		for name := range layerOpaque {
+			opaque[name] = true
+		}
+		survivors[i] = index
+	}
+
+	tw := tar.NewWriter(w)
+	for i, layerPath := range layerPaths {
+		err := readLayer(layerPath, func(n int, hdr *tar.Header, r io.Reader) error {
+			name := cleanEntryName(hdr.Name)
+			if last, ok := survivors[i][name]; !ok || last != n {
+				return nil
+			}
+			hdr.Name = name
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Name += "/"
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = cleanEntryName(hdr.Linkname)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to flatten layer %s: %w", layerPath, err)
+		}
+	}
+	return tw.Close()
+}
+
+// isHidden reports whether an entry of a layer is hidden by the layers above it: deleted by a whiteout,
+// replaced by an upper entry or under an opaque directory, a deleted directory or a replacing file
+func isHidden(name string, seen, deleted, opaque map[string]bool) bool {
+	if _, ok := seen[name]; ok || deleted[name] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if isDir, ok := seen[dir]; (ok && !isDir) || deleted[dir] || opaque[dir] {
+			return true
+		}
+	}
+	return opaque[""]
+}
+
+// cleanEntryName returns the relative form of a tar entry name without a trailing slash, like etc/passwd
+// for ./etc/passwd, and an empty name for the root
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// readLayer calls fn with the index, header and contents of every entry of the layer tar
+func readLayer(layerPath string, fn func(n int, hdr *tar.Header, r io.Reader) error) error {
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	layer, err := compression.DecompressStream(file)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	tr := tar.NewReader(layer)
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(n, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// FlattenImageDir writes the flattened filesystem of the first image of an extracted docker-archive in dir
+// to outputTarPath, see FlattenLayersWithLimits for the MaxFiles and MaxBytes limits of opts. The tar is removed
+// when flattening fails.
+func FlattenImageDir(dir, outputTarPath string, opts models.ExtractOptions) error {
+	manifestPath := filepath.Join(dir, "manifest.json")
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var manifests []archiveManifest
+	if err = json.Unmarshal(data, &manifests); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", manifestPath, err)
+	}
+	if len(manifests) == 0 {
+		return fmt.Errorf("no image in %s", manifestPath)
+	}
+	layerPaths := make([]string, 0, len(manifests[0].Layers))
+	for _, layer := range manifests[0].Layers {
+		layerPaths = append(layerPaths, filepath.Join(dir, layer))
+	}
+	out, err := os.Create(outputTarPath)
+	if err != nil {
+		return err
+	}
+	if err = FlattenLayersWithLimits(layerPaths, out, opts.MaxFiles, opts.MaxBytes); err != nil {
+		out.Close()
+		os.Remove(outputTarPath)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(outputTarPath)
+		return err
+	}
+	return nil
+}
+
+// ExtractFlattened calls extract to extract an image into a new directory of opts.WorkDir, or next to outputTarPath
+// when it is empty, then flattens the extracted docker-archive into outputTarPath within the limits of opts. The
+// directory is removed once done.
+func ExtractFlattened(outputTarPath string, opts models.ExtractOptions, extract func(dir string) error) error {
+	workDir := opts.WorkDir
+	if workDir == "" {
+		workDir = filepath.Dir(outputTarPath)
+	}
+	dir, err := ioutil.TempDir(workDir, "vessel-flatten-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err = extract(dir); err != nil {
+		return err
+	}
+	return FlattenImageDir(dir, outputTarPath, opts)
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// layerTar returns a layer tar of the entries, given as "dir/" for directories, "name->target" for hardlinks
+// and "name=content" for regular files, whiteouts included
+func layerTar(t *testing.T, entries ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Mode: 0644}
+		var content string
+		switch {
+		case strings.HasSuffix(entry, "/"):
+			hdr.Name, hdr.Typeflag, hdr.Mode = entry, tar.TypeDir, 0755
+		case strings.Contains(entry, "->"):
+			parts := strings.SplitN(entry, "->", 2)
+			hdr.Name, hdr.Typeflag, hdr.Linkname = parts[0], tar.TypeLink, parts[1]
+		default:
+			parts := strings.SplitN(entry, "=", 2)
+			hdr.Name, hdr.Typeflag = parts[0], tar.TypeReg
+			if len(parts) == 2 {
+				content = parts[1]
+			}
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarEntries returns the entries of a filesystem tar in order, in the notation of layerTar
+func tarEntries(t *testing.T, r io.Reader) []string {
+	var entries []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entries = append(entries, hdr.Name)
+		case tar.TypeLink:
+			entries = append(entries, hdr.Name+"->"+hdr.Linkname)
+		default:
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, hdr.Name+"="+string(content))
+		}
+	}
+}
+
+func TestFlattenLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers [][]string
+		want   []string
+	}{
+		{
+			name: "whiteout deletes a lower file",
+			layers: [][]string{
+				{"etc/", "etc/passwd=root", "etc/shadow=secret"},
+				{"etc/.wh.shadow"},
+			},
+			want: []string{"etc/", "etc/passwd=root"},
+		},
+		{
+			name: "upper file replaces a lower file",
+			layers: [][]string{
+				{"etc/", "etc/passwd=root"},
+				{"etc/passwd=root,app"},
+			},
+			want: []string{"etc/", "etc/passwd=root,app"},
+		},
+		{
+			name: "opaque directory hides the lower contents but keeps its own",
+			layers: [][]string{
+				{"app/", "app/old=1", "app/lib/", "app/lib/old.so=2"},
+				{"app/", "app/.wh..wh..opq", "app/new=3"},
+			},
+			want: []string{"app/", "app/new=3"},
+		},
+		{
+			name: "opaque directory keeps entries written before the marker",
+			layers: [][]string{
+				{"app/", "app/old=1"},
+				{"app/", "app/new=3", "app/.wh..wh..opq"},
+			},
+			want: []string{"app/", "app/new=3"},
+		},
+		{
+			name: "file replaces a lower directory",
+			layers: [][]string{
+				{"opt/", "opt/tool/", "opt/tool/bin=elf"},
+				{"opt/tool=script"},
+			},
+			want: []string{"opt/", "opt/tool=script"},
+		},
+		{
+			name: "hardlink written after its target",
+			layers: [][]string{
+				{"bin/", "bin/busybox=elf"},
+				{"bin/sh->bin/busybox"},
+			},
+			want: []string{"bin/", "bin/busybox=elf", "bin/sh->bin/busybox"},
+		},
+		{
+			name: "deleted directory hides its lower contents",
+			layers: [][]string{
+				{"var/", "var/cache/", "var/cache/apk=index"},
+				{"var/.wh.cache"},
+			},
+			want: []string{"var/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers := make([][]byte, len(tt.layers))
+			for i, entries := range tt.layers {
+				layers[i] = layerTar(t, entries...)
+			}
+			var dst bytes.Buffer
+			if err := FlattenLayersWithLimits(writeLayers(t, layers...), &dst, 0, 0); err != nil {
+				t.Fatalf("FlattenLayersWithLimits() error = %v", err)
+			}
+			if got := tarEntries(t, &dst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flattened = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFlattenedWorkDir(t *testing.T) {
+	workDir, outputDir := t.TempDir(), t.TempDir()
+	outputTarPath := filepath.Join(outputDir, "rootfs.tar")
+	opts := models.ExtractOptions{WorkDir: workDir}
+	err := ExtractFlattened(outputTarPath, opts, func(dir string) error {
+		if filepath.Dir(dir) != workDir {
+			t.Errorf("image extracted to %s, want a directory of the work dir %s", dir, workDir)
+		}
+		layer := layerTar(t, "etc/", "etc/os-release=ID=alpine")
+		if err := ioutil.WriteFile(filepath.Join(dir, "layer.tar"), layer, 0644); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`[{"Layers":["layer.tar"]}]`), 0644)
+	})
+	if err != nil {
+		t.Fatalf("ExtractFlattened() error = %v", err)
+	}
+	out, err := os.Open(outputTarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if got, want := tarEntries(t, out), []string{"etc/", "etc/os-release=ID=alpine"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flattened = %v, want %v", got, want)
+	}
+	// the staging directory is removed and nothing but the output is left next to it
+	for dir, want := range map[string]int{workDir: 0, outputDir: 1} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != want {
+			t.Errorf("%s holds %d entries after flattening, want %d", dir, len(entries), want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"path"
+	"regexp"
+)
+
+// ImageNameFilter matches image names against a glob and a regular expression, see models.ListImagesOptions
+type ImageNameFilter struct {
+	pattern string
+	regexp  *regexp.Regexp
+}
+
+// NewImageNameFilter validates the glob pattern and regular expression of an image name filter,
+// either may be empty to not filter on it
+func NewImageNameFilter(pattern, expr string) (*ImageNameFilter, error) {
+	filter := &ImageNameFilter{pattern: pattern}
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid image name pattern %q: %v", pattern, err)
+		}
+	}
+	if expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image name regexp %q: %v", expr, err)
+		}
+		filter.regexp = re
+	}
+	return filter, nil
+}
+
+// Matches reports whether the image name matches the filter. The glob is matched like docker's reference
+// filter against the familiar name, with and without its tag, like myregistry.com/app or nginx:latest.
+// The regular expression is matched against the fully qualified name, like docker.io/library/nginx:latest.
+func (f *ImageNameFilter) Matches(name string) bool {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return false
+	}
+	if f.pattern != "" {
+		if matched, err := reference.FamiliarMatch(f.pattern, named); err != nil || !matched {
+			return false
+		}
+	}
+	return f.regexp == nil || f.regexp.MatchString(named.String())
+}
+
+// IsEmpty reports whether the filter matches every image, including untagged ones
+func (f *ImageNameFilter) IsEmpty() bool {
+	return f.pattern == "" && f.regexp == nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/filters"
+	"strings"
+)
+
+// LabelRequirement is a single requirement of a label selector
+type LabelRequirement struct {
+	Key   string
+	Value string
+	// Exists only requires the label to be set, whatever its value
+	Exists bool
+}
+
+// LabelSelector selects the containers whose labels meet all of its requirements
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a comma separated label selector, each requirement either key=value
+// or key for the label to be set, like scanned=true,io.kubernetes.pod.name. An empty selector
+// selects every container.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var parsed LabelSelector
+	if strings.TrimSpace(selector) == "" {
+		return parsed, nil
+	}
+	for _, requirement := range strings.Split(selector, ",") {
+		requirement = strings.TrimSpace(requirement)
+		key, value, hasValue := requirement, "", false
+		if i := strings.Index(requirement, "="); i >= 0 {
+			key, value, hasValue = requirement[:i], strings.TrimPrefix(requirement[i+1:], "="), true
+		}
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, " !=") || strings.ContainsAny(value, "=,") {
+			return nil, fmt.Errorf("invalid label selector %q: malformed requirement %q", selector, requirement)
+		}
+		parsed = append(parsed, LabelRequirement{Key: key, Value: strings.TrimSpace(value), Exists: !hasValue})
+	}
+	return parsed, nil
+}
+
+// Matches reports whether the labels meet all requirements of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.Key]
+		if !ok || (!requirement.Exists && value != requirement.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Args returns the requirements in the key and key=value form of docker's label filter
+func (s LabelSelector) Args() []string {
+	args := make([]string, 0, len(s))
+	for _, requirement := range s {
+		if requirement.Exists {
+			args = append(args, requirement.Key)
+		} else {
+			args = append(args, requirement.Key+"="+requirement.Value)
+		}
+	}
+	return args
+}
+
+// DockerFilters returns the label filters of docker's container list for the selector
+func (s LabelSelector) DockerFilters() filters.Args {
+	args := filters.NewArgs()
+	for _, arg := range s.Args() {
+		args.Add("label", arg)
+	}
+	return args
+}
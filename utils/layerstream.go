@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"sync"
+)
+
+// layerReader hands a layer tar to the consumer of a layer stream, signalling done once the layer
+// is read to its end or closed so the producer can move on to the next one
+type layerReader struct {
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+	done   chan struct{}
+}
+
+func (l *layerReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := l.r.Read(p)
+	if err != nil {
+		l.finish()
+	}
+	return n, err
+}
+
+// Close skips the rest of the layer
+func (l *layerReader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.finish()
+	}
+	return nil
+}
+
+func (l *layerReader) finish() {
+	l.closed = true
+	close(l.done)
+}
+
+// SendLayer sends the layer tar read from r on layers, blocking until the consumer has read it to the end or
+// closed it, so only one layer is in flight at a time and r may be a section of a larger stream.
+// The context error is returned once ctx is done.
+func SendLayer(ctx context.Context, layers chan<- models.LayerResult, digest string, r io.Reader) error {
+	lr := &layerReader{r: r, done: make(chan struct{})}
+	select {
+	case layers <- models.LayerResult{Digest: digest, Reader: lr}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-lr.done:
+		return nil
+	case <-ctx.Done():
+		lr.Close()
+		return ctx.Err()
+	}
+}
+
+// SendLayerError sends the error ending a layer stream on layers, unless ctx is done
+func SendLayerError(ctx context.Context, layers chan<- models.LayerResult, err error) {
+	select {
+	case layers <- models.LayerResult{Err: err}:
+	case <-ctx.Done():
+	}
+}
+
+// IsTar reports whether the stream buffered by r starts with a tar header, for telling layer blobs from
+// the config and manifest blobs of an OCI layout
+func IsTar(r *bufio.Reader) bool {
+	header, err := r.Peek(512)
+	if err != nil || len(header) < 262 {
+		return false
+	}
+	return bytes.Equal(header[257:262], []byte("ustar"))
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"archive/tar"
+	"bufio"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/deepfence/vessel/models"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrExtractLimitExceeded is returned when an image has more files or bytes than the extraction limits allow
+var ErrExtractLimitExceeded = errors.New("extraction limit exceeded")
+
+// extractLimiter counts the files and decompressed bytes of an image tar stream against the limits,
+// 0 is unlimited
+type extractLimiter struct {
+	maxFiles int
+	maxBytes int64
+	files    int
+	bytes    int64
+}
+
+// add accounts an entry of size bytes
+func (l *extractLimiter) add(name string, size int64) error {
+	l.files++
+	l.bytes += size
+	if l.maxFiles > 0 && l.files > l.maxFiles {
+		return errors.Wrapf(ErrExtractLimitExceeded, "more than %d files at %s", l.maxFiles, name)
+	}
+	if l.maxBytes > 0 && l.bytes > l.maxBytes {
+		return errors.Wrapf(ErrExtractLimitExceeded, "more than %d bytes at %s", l.maxBytes, name)
+	}
+	return nil
+}
+
+// addLayer accounts the entries of a layer tar, decompressing it when compressed. Entries which aren't
+// tars, like configs and manifests, are accounted as a single file.
+func (l *extractLimiter) addLayer(hdr *tar.Header, r io.Reader) error {
+	br := bufio.NewReader(r)
+	layer, err := compression.DecompressStream(br)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	lr := bufio.NewReader(layer)
+	if !IsTar(lr) {
+		return l.add(hdr.Name, hdr.Size)
+	}
+	ltr := tar.NewReader(lr)
+	for {
+		entry, err := ltr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, " :error reading layer %s", hdr.Name)
+		}
+		if err = l.add(hdr.Name+":"+entry.Name, entry.Size); err != nil {
+			return err
+		}
+	}
+	// the rest of the entry is still copied to dst
+	_, err = io.Copy(ioutil.Discard, br)
+	return err
+}
+
+// CopyWithLimits copies an image tar stream from src to dst like CopyWithProgress, failing with
+// ErrExtractLimitExceeded once the files or decompressed bytes of the image layers exceed maxFiles or
+// maxBytes, 0 is unlimited. The entries of every layer tar count towards the totals, so a tar bomb is
+// caught as its layer is copied rather than when it is flattened. progress may be nil.
+func CopyWithLimits(dst io.Writer, src io.Reader, total int64, progress models.ProgressFunc, maxFiles int, maxBytes int64) error {
+	pw := &progressWriter{w: dst, total: total, progress: progress}
+	limiter := &extractLimiter{maxFiles: maxFiles, maxBytes: maxBytes}
+	tr := tar.NewReader(io.TeeReader(src, pw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pw.layer = hdr.Name
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			if err = limiter.add(hdr.Name, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = limiter.addLayer(hdr, tr); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(pw, src)
+	return err
+}
+
+// CopyImageTar copies an image tar stream from src to dst, reporting progress and enforcing the
+// file and byte limits of opts when set
+func CopyImageTar(dst io.Writer, src io.Reader, total int64, opts models.ExtractOptions) error {
+	if opts.MaxFiles > 0 || opts.MaxBytes > 0 {
+		return CopyWithLimits(dst, src, total, opts.Progress, opts.MaxFiles, opts.MaxBytes)
+	}
+	return CopyWithProgress(dst, src, total, opts.Progress)
+}
+
+// CopyFilesystemTarWithLimits copies a filesystem tar, like a flattened image or an exported container, from src
+// to dst, failing with ErrExtractLimitExceeded once its entries exceed maxFiles or their bytes exceed maxBytes,
+// 0 is unlimited
+func CopyFilesystemTarWithLimits(dst io.Writer, src io.Reader, maxFiles int, maxBytes int64) error {
+	limiter := &extractLimiter{maxFiles: maxFiles, maxBytes: maxBytes}
+	tr := tar.NewReader(io.TeeReader(src, dst))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = limiter.add(hdr.Name, hdr.Size); err != nil {
+			return err
+		}
+	}
+	// copy the padding after the end of archive marker too
+	_, err := io.Copy(dst, src)
+	return err
+}
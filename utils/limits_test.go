@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"github.com/deepfence/vessel/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fsTar returns a filesystem tar of regular files, given as name and content pairs
+func fsTar(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(files[i+1]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeLayers writes the layer tars to files of a temporary directory, returning their paths
+func writeLayers(t *testing.T, layers ...[]byte) []string {
+	dir := t.TempDir()
+	paths := make([]string, len(layers))
+	for i, layer := range layers {
+		paths[i] = filepath.Join(dir, "layer"+string(rune('0'+i))+".tar")
+		if err := ioutil.WriteFile(paths[i], layer, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestCopyFilesystemTarWithLimits(t *testing.T) {
+	src := fsTar(t, "etc/passwd", "root", "etc/group", "root", "bin/sh", "elf")
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxBytes int64
+		wantErr  bool
+	}{
+		{name: "unlimited"},
+		{name: "within limits", maxFiles: 3, maxBytes: 11},
+		{name: "too many files", maxFiles: 2, wantErr: true},
+		{name: "too many bytes", maxBytes: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst bytes.Buffer
+			err := CopyFilesystemTarWithLimits(&dst, bytes.NewReader(src), tt.maxFiles, tt.maxBytes)
+			if tt.wantErr {
+				if !errors.Is(err, ErrExtractLimitExceeded) {
+					t.Fatalf("CopyFilesystemTarWithLimits() error = %v, want ErrExtractLimitExceeded", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CopyFilesystemTarWithLimits() error = %v", err)
+			}
+			if !bytes.Equal(dst.Bytes(), src) {
+				t.Error("the copied tar differs from the source")
+			}
+		})
+	}
+}
+
+func TestFlattenLayersWithLimits(t *testing.T) {
+	layers := writeLayers(t, fsTar(t, "etc/passwd", "root", "bin/sh", "elf"), fsTar(t, "etc/passwd", "admin"))
+	var out bytes.Buffer
+	if err := FlattenLayersWithLimits(layers, &out, 3, 0); err != nil {
+		t.Fatalf("FlattenLayersWithLimits() within the limits error = %v", err)
+	}
+	out.Reset()
+	// the replaced passwd of the base layer still counts, it is read and decompressed all the same
+	err := FlattenLayersWithLimits(layers, &out, 2, 0)
+	if !errors.Is(err, ErrExtractLimitExceeded) {
+		t.Fatalf("FlattenLayersWithLimits() error = %v, want ErrExtractLimitExceeded", err)
+	}
+	if out.Len() != 0 {
+		t.Error("entries were written before the limits were checked")
+	}
+	err = FlattenLayersWithLimits(layers, &out, 0, 8)
+	if !errors.Is(err, ErrExtractLimitExceeded) {
+		t.Fatalf("FlattenLayersWithLimits() error = %v, want ErrExtractLimitExceeded", err)
+	}
+}
+
+func TestFlattenImageDirRemovesOutputOverLimit(t *testing.T) {
+	dir := t.TempDir()
+	layer := fsTar(t, "a", "1", "b", "2")
+	if err := ioutil.WriteFile(filepath.Join(dir, "layer.tar"), layer, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`[{"Layers":["layer.tar"]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputTarPath := filepath.Join(t.TempDir(), "rootfs.tar")
+	err := FlattenImageDir(dir, outputTarPath, models.ExtractOptions{MaxFiles: 1})
+	if !errors.Is(err, ErrExtractLimitExceeded) {
+		t.Fatalf("FlattenImageDir() error = %v, want ErrExtractLimitExceeded", err)
+	}
+	if _, err = os.Stat(outputTarPath); !os.IsNotExist(err) {
+		t.Errorf("output tar left behind: %v", err)
+	}
+}
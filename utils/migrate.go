@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// MigrateOCIToDockerV1 migrates the OCI image layout in path to a docker v1 image, extracted into path
+func MigrateOCIToDockerV1(path, imageID, tarFilePath string) error {
+	if tarFilePath == "" {
+		tarFilePath = path + imageID + ".tar"
+	}
+	return migrateOCIToDockerV1(path, tarFilePath, "")
+}
+
+// MigrateOCIToDockerV1InWorkDir is MigrateOCIToDockerV1 writing the intermediate docker archive and the temporary
+// files of skopeo to a new directory in workDir, which is removed once the migration returns. An empty workDir
+// writes the archive next to path like MigrateOCIToDockerV1.
+func MigrateOCIToDockerV1InWorkDir(path, imageID, workDir string) error {
+	if workDir == "" {
+		return MigrateOCIToDockerV1(path, imageID, "")
+	}
+	scratchDir, err := ioutil.TempDir(workDir, "vessel-extract-")
+	if err != nil {
+		return fmt.Errorf("failed to create scratch directory in work dir: %v", err)
+	}
+	defer os.RemoveAll(scratchDir)
+	return migrateOCIToDockerV1(path, filepath.Join(scratchDir, "image.tar"), scratchDir)
+}
+
+// migrateOCIToDockerV1 converts the OCI layout in path through the docker archive at tarFilePath,
+// skopeo's temporary files go to tmpDir when set. The archive is removed whether or not it succeeds.
+func migrateOCIToDockerV1(path, tarFilePath, tmpDir string) error {
+	sourceDir := "oci://" + path
+	destinationTar := "docker-archive:" + tarFilePath
+	var stderr bytes.Buffer
+
+	// skopeo will convert oci dir into docker v1 tarball
+	skopeoCopy := exec.Command("/usr/bin/skopeo", "copy", sourceDir, destinationTar)
+	if tmpDir != "" {
+		skopeoCopy.Env = append(os.Environ(), "TMPDIR="+tmpDir)
+	}
+	skopeoCopy.Stderr = &stderr
+	err := skopeoCopy.Run()
+	if err != nil {
+		os.Remove(tarFilePath)
+		return fmt.Errorf("failed to migrate OCI to Docker image: %v", stderr)
+	}
+
+	// untar the docker archive
+	tarxf := exec.Command("tar", "xf", tarFilePath, "--warning=none", "-C"+path)
+	tarxf.Stderr = &stderr
+	err = tarxf.Run()
+	if err != nil {
+		os.Remove(tarFilePath)
+		return fmt.Errorf("failed to migrate OCI to Docker image: %v", err)
+	}
+
+	// delete docker tar, not required
+	removeTar := exec.Command("rm", tarFilePath)
+	removeTar.Stderr = &stderr
+	err = removeTar.Run()
+	if err != nil {
+		return fmt.Errorf("failed to delete generated docker-archive: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"archive/tar"
+	"github.com/deepfence/vessel/models"
+	"io"
+)
+
+// progressWriter forwards writes to w and reports the running byte count to progress
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	layer    string
+	progress models.ProgressFunc
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if p.progress != nil {
+		p.progress(p.done, p.total, p.layer)
+	}
+	return n, err
+}
+
+// CopyWithProgress copies an image tar stream from src to dst, calling progress as bytes are copied
+// with the name of the tar entry being copied. total is the expected stream size, 0 when unknown.
+func CopyWithProgress(dst io.Writer, src io.Reader, total int64, progress models.ProgressFunc) error {
+	pw := &progressWriter{w: dst, total: total, progress: progress}
+	// every byte the tar reader consumes is teed to dst,
+	// so the stream is copied while the entry names are tracked
+	tr := tar.NewReader(io.TeeReader(src, pw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pw.layer = hdr.Name
+	}
+	// copy the end of archive padding not read by the tar reader
+	_, err := io.Copy(pw, src)
+	return err
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/deepfence/vessel/models"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubConfigKey is the key docker hub credentials are stored under in config.json
+const dockerHubConfigKey = "https://index.docker.io/v1/"
+
+// dockerConfig is the part of a docker config.json holding registry credentials
+type dockerConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type dockerConfigAuth struct {
+	// Auth is the base64 encoded username:password
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// RegistryAuth returns the credentials to use for the registry host. The credentials set in auth are returned
+// as they are, otherwise they are looked up in the docker config.json, including its credential helpers.
+// Empty credentials are returned when the config has none for the registry.
+func RegistryAuth(auth models.AuthConfig, host string) (models.AuthConfig, error) {
+	if auth.Username != "" || auth.Password != "" || auth.IdentityToken != "" {
+		return auth, nil
+	}
+	configFile := auth.ConfigFile
+	if configFile == "" {
+		configFile = defaultDockerConfigFile()
+		if configFile == "" {
+			return models.AuthConfig{}, nil
+		}
+	}
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		// the default config is optional, an explicitly given one isn't
+		if os.IsNotExist(err) && auth.ConfigFile == "" {
+			return models.AuthConfig{}, nil
+		}
+		return models.AuthConfig{}, fmt.Errorf("failed to read docker config %s: %v", configFile, err)
+	}
+	var config dockerConfig
+	if err = json.Unmarshal(content, &config); err != nil {
+		return models.AuthConfig{}, fmt.Errorf("failed to parse docker config %s: %v", configFile, err)
+	}
+
+	key := registryConfigKey(host)
+	if helper, ok := config.CredHelpers[key]; ok {
+		return credentialHelperAuth(helper, key)
+	}
+	for serverAddress, entry := range config.Auths {
+		if registryConfigKey(serverAddress) != key {
+			continue
+		}
+		if entry.IdentityToken != "" {
+			return models.AuthConfig{IdentityToken: entry.IdentityToken}, nil
+		}
+		return decodeConfigAuth(entry.Auth)
+	}
+	if config.CredsStore != "" {
+		return credentialHelperAuth(config.CredsStore, key)
+	}
+	return models.AuthConfig{}, nil
+}
+
+// defaultDockerConfigFile returns $DOCKER_CONFIG/config.json, falling back to ~/.docker/config.json
+func defaultDockerConfigFile() string {
+	if configDir := os.Getenv("DOCKER_CONFIG"); configDir != "" {
+		return filepath.Join(configDir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// registryConfigKey normalizes a registry host or config.json server address to the key
+// credentials are stored under, docker hub's host names all map to its legacy v1 address
+func registryConfigKey(serverAddress string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(serverAddress, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubConfigKey
+	}
+	return host
+}
+
+// decodeConfigAuth decodes the base64 username:password auth of a config.json entry
+func decodeConfigAuth(encoded string) (models.AuthConfig, error) {
+	if encoded == "" {
+		return models.AuthConfig{}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return models.AuthConfig{}, fmt.Errorf("invalid auth in docker config: %v", err)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return models.AuthConfig{}, fmt.Errorf("invalid auth in docker config, expected username:password")
+	}
+	return models.AuthConfig{Username: parts[0], Password: parts[1]}, nil
+}
+
+// credentialHelperAuth gets the credentials of the server from the docker-credential-<helper> program
+func credentialHelperAuth(helper, serverAddress string) (models.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// helpers report missing credentials on stdout
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return models.AuthConfig{}, nil
+		}
+		return models.AuthConfig{}, fmt.Errorf("docker-credential-%s failed for %s: %v", helper, serverAddress, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return models.AuthConfig{}, fmt.Errorf("invalid output of docker-credential-%s: %v", helper, err)
+	}
+	// helpers store identity tokens with a <token> username
+	if creds.Username == "<token>" {
+		return models.AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return models.AuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
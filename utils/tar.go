@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/containerd/continuity/fs"
+	"github.com/deepfence/vessel/models"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// paxXattrPrefix is the PAX record prefix GNU tar and docker use for extended attributes
+const paxXattrPrefix = "SCHILY.xattr."
+
+// TarDirectory writes the tree under root to w as a tar archive. Unlike a plain tar writer it keeps
+// what matters for security scanning: ownership, extended attributes like security.capability and
+// security.selinux, hardlinks and device nodes. Sockets are skipped, they can't be archived.
+func TarDirectory(root string, w io.Writer, opts models.TarOptions) error {
+	tw := tar.NewWriter(w)
+	// the archived name of the first path seen for each inode, later paths become hardlinks to it
+	hardlinks := make(map[inode]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		source := path
+		if info.Mode()&os.ModeSymlink != 0 && opts.FollowSymlinks {
+			if resolved, resolvedInfo, ok := resolveSymlink(root, rel); ok {
+				source, info = resolved, resolvedInfo
+			}
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(source); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %v", name, err)
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Format = tar.FormatPAX
+
+		if info.Mode().IsRegular() {
+			if id, ok := fileInode(info); ok {
+				if target, seen := hardlinks[id]; seen {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = target
+					hdr.Size = 0
+				} else {
+					hardlinks[id] = name
+				}
+			}
+		}
+
+		xattrs, err := readXattrs(source)
+		if err != nil {
+			return fmt.Errorf("failed to read extended attributes of %s: %v", name, err)
+		}
+		if len(xattrs) > 0 {
+			hdr.PAXRecords = make(map[string]string, len(xattrs))
+			for key, value := range xattrs {
+				hdr.PAXRecords[paxXattrPrefix+key] = value
+			}
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		file, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// resolveSymlink resolves the symlink at rel within root, like it would be resolved in a container with
+// root as its rootfs, reporting false for dangling symlinks and symlinks to directories
+func resolveSymlink(root, rel string) (string, os.FileInfo, bool) {
+	resolved, err := fs.RootPath(root, rel)
+	if err != nil {
+		return "", nil, false
+	}
+	info, err := os.Lstat(resolved)
+	if err != nil || info.IsDir() {
+		return "", nil, false
+	}
+	return resolved, info, true
+}
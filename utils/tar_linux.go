@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// inode identifies a file across hardlinks
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileInode returns the inode of a file with more than one hardlink
+func fileInode(info os.FileInfo) (inode, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
+
+// readXattrs returns the extended attributes of the file, without following symlinks
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(path, names); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err == unix.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value)
+	}
+	return xattrs, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
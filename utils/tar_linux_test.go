@@ -0,0 +1,171 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"github.com/deepfence/vessel/models"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archivedEntry is a header of an archive along with the content of its entry
+type archivedEntry struct {
+	hdr     *tar.Header
+	content string
+}
+
+// tarDirectory archives the tree under root with TarDirectory, returning its entries by name
+func tarDirectory(t *testing.T, root string, opts models.TarOptions) map[string]archivedEntry {
+	var buf bytes.Buffer
+	if err := TarDirectory(root, &buf, opts); err != nil {
+		t.Fatalf("TarDirectory() error = %v", err)
+	}
+	entries := make(map[string]archivedEntry)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = archivedEntry{hdr: hdr, content: string(content)}
+	}
+}
+
+// writeFile writes a file under root, creating its parent directories
+func writeFile(t *testing.T, root, name, content string) string {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// capNetBindService is the security.capability value setcap cap_net_bind_service+ep writes,
+// a revision 2 vfs_cap_data with the effective flag
+func capNetBindService() []byte {
+	data := make([]byte, 20)
+	binary.LittleEndian.PutUint32(data[0:], 0x02000000|0x1)
+	binary.LittleEndian.PutUint32(data[4:], 1<<unix.CAP_NET_BIND_SERVICE)
+	return data
+}
+
+func TestTarDirectoryXattrs(t *testing.T) {
+	root := t.TempDir()
+	path := writeFile(t, root, "usr/bin/ping", "elf")
+	xattrs := map[string][]byte{
+		"user.vessel.scan":    []byte("yes"),
+		"security.capability": capNetBindService(),
+	}
+	for name, value := range xattrs {
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			if err == unix.ENOTSUP || err == unix.EPERM {
+				t.Skipf("setting %s: %v", name, err)
+			}
+			t.Fatal(err)
+		}
+	}
+
+	entries := tarDirectory(t, root, models.TarOptions{})
+	entry, ok := entries["usr/bin/ping"]
+	if !ok {
+		t.Fatalf("usr/bin/ping not archived, got %v", entries)
+	}
+	for name, value := range xattrs {
+		if got := entry.hdr.PAXRecords[paxXattrPrefix+name]; got != string(value) {
+			t.Errorf("PAX record %s = %q, want %q", name, got, value)
+		}
+	}
+	if entry.content != "elf" {
+		t.Errorf("content = %q, want elf", entry.content)
+	}
+}
+
+func TestTarDirectoryHardlinks(t *testing.T) {
+	root := t.TempDir()
+	first := writeFile(t, root, "bin/a", "busybox")
+	if err := os.Link(first, filepath.Join(root, "bin/b")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := tarDirectory(t, root, models.TarOptions{})
+	if hdr := entries["bin/a"].hdr; hdr == nil || hdr.Typeflag != tar.TypeReg || entries["bin/a"].content != "busybox" {
+		t.Fatalf("bin/a = %+v, want the regular file", hdr)
+	}
+	hdr := entries["bin/b"].hdr
+	if hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "bin/a" || hdr.Size != 0 {
+		t.Fatalf("bin/b = %+v, want a hardlink to bin/a", hdr)
+	}
+}
+
+func TestTarDirectoryDeviceNodes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dev"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the character device of /dev/null
+	if err := unix.Mknod(filepath.Join(root, "dev/null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil {
+		t.Skipf("mknod: %v", err)
+	}
+
+	entries := tarDirectory(t, root, models.TarOptions{})
+	hdr := entries["dev/null"].hdr
+	if hdr == nil || hdr.Typeflag != tar.TypeChar || hdr.Devmajor != 1 || hdr.Devminor != 3 {
+		t.Fatalf("dev/null = %+v, want character device 1:3", hdr)
+	}
+}
+
+func TestTarDirectorySymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "etc/os-release", "ID=alpine")
+	symlinks := map[string]string{
+		// absolute symlinks resolve within the tree, not on the host
+		"etc/absolute": "/etc/os-release",
+		"etc/relative": "os-release",
+		"etc/dir":      "/etc",
+		"etc/dangling": "/missing",
+	}
+	for name, target := range symlinks {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := tarDirectory(t, root, models.TarOptions{})
+	for name, target := range symlinks {
+		hdr := entries[name].hdr
+		if hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != target {
+			t.Errorf("%s = %+v, want a symlink to %s", name, hdr, target)
+		}
+	}
+
+	entries = tarDirectory(t, root, models.TarOptions{FollowSymlinks: true})
+	for _, name := range []string{"etc/absolute", "etc/relative"} {
+		entry := entries[name]
+		if entry.hdr == nil || entry.hdr.Typeflag != tar.TypeReg || entry.content != "ID=alpine" {
+			t.Errorf("%s = %+v, want the followed file", name, entry.hdr)
+		}
+	}
+	for _, name := range []string{"etc/dir", "etc/dangling"} {
+		if hdr := entries[name].hdr; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != symlinks[name] {
+			t.Errorf("%s = %+v, want it kept as a symlink", name, hdr)
+		}
+	}
+}
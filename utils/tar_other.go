@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import "os"
+
+// inode identifies a file across hardlinks
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// fileInode reports false, hardlinks are only detected on linux
+func fileInode(os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
+
+// readXattrs returns no extended attributes, they are only read on linux
+func readXattrs(string) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveManifest is an entry of the manifest.json of a docker-archive image tarball
+type archiveManifest struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+// imageConfig is the subset of an image config holding the layer diff ids
+type imageConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// VerifyImageDigests checks an extracted image tarball in dir against its digests: the layers listed
+// in a docker-archive manifest.json must hash to the diff ids of the image config, and the blobs of an
+// OCI layout must hash to their names. A mismatch returns an error identifying the layer.
+func VerifyImageDigests(dir string) error {
+	manifestPath := filepath.Join(dir, "manifest.json")
+	if _, err := os.Stat(manifestPath); err == nil {
+		if err := verifyArchiveLayers(dir, manifestPath); err != nil {
+			return err
+		}
+	}
+	blobsDir := filepath.Join(dir, "blobs", "sha256")
+	if _, err := os.Stat(blobsDir); err == nil {
+		if err := verifyOCIBlobs(blobsDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyArchiveLayers(dir, manifestPath string) error {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var manifests []archiveManifest
+	if err := json.Unmarshal(data, &manifests); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", manifestPath, err)
+	}
+	for _, manifest := range manifests {
+		data, err := ioutil.ReadFile(filepath.Join(dir, manifest.Config))
+		if err != nil {
+			return err
+		}
+		var config imageConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse image config %s: %v", manifest.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+			return fmt.Errorf("image config %s lists %d layers, manifest has %d",
+				manifest.Config, len(config.RootFS.DiffIDs), len(manifest.Layers))
+		}
+		for i, layer := range manifest.Layers {
+			if err := verifyFileDigest(filepath.Join(dir, layer), config.RootFS.DiffIDs[i]); err != nil {
+				return fmt.Errorf("layer %d (%s): %v", i, layer, err)
+			}
+		}
+	}
+	return nil
+}
+
+func verifyOCIBlobs(blobsDir string) error {
+	blobs, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if blob.IsDir() {
+			continue
+		}
+		if err := verifyFileDigest(filepath.Join(blobsDir, blob.Name()), "sha256:"+blob.Name()); err != nil {
+			return fmt.Errorf("blob sha256:%s: %v", blob.Name(), err)
+		}
+	}
+	return nil
+}
+
+// verifyFileDigest checks that the sha256 of the file matches the expected sha256:<hex> digest
+func verifyFileDigest(path, expected string) error {
+	if !strings.HasPrefix(expected, "sha256:") {
+		return fmt.Errorf("unsupported digest algorithm in %s", expected)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	actual := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("digest mismatch, expected %s, got %s", expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package vessel
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// CheckRuntimeCompatibility reports whether the runtime at sockPath is at least minVersion, like 20.10 for
+// docker or 1.5 for containerd. The runtime is auto detected when runtime and sockPath are empty.
+// An older runtime returns false along with an ErrIncompatibleRuntime error naming both versions.
+func CheckRuntimeCompatibility(runtime, sockPath, minVersion string) (bool, error) {
+	required, err := parseVersion(minVersion)
+	if err != nil {
+		return false, err
+	}
+	if runtime == "" && sockPath == "" {
+		runtime, sockPath, err = AutoDetectRuntime()
+		if err != nil {
+			return false, err
+		}
+	}
+	version, _, err := queryRuntimeVersion(runtime, sockPath, Options{})
+	if err != nil {
+		return false, err
+	}
+	actual, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	if compareVersions(actual, required) < 0 {
+		return false, errors.Wrapf(ErrIncompatibleRuntime, "%s %s is older than the required %s", runtime, version, minVersion)
+	}
+	return true, nil
+}
+
+// parseVersion parses the numeric major.minor.patch part of a runtime version like v1.5.0-beta.4 or 20.10.6,
+// pre-release and build suffixes are ignored
+func parseVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if end := strings.IndexFunc(trimmed, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); end >= 0 {
+		trimmed = trimmed[:end]
+	}
+	trimmed = strings.TrimSuffix(trimmed, ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+	var parts []int
+	for _, part := range strings.Split(trimmed, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// compareVersions returns -1, 0 or 1 when a is older than, equal to or newer than b,
+// missing trailing parts count as 0
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+// Package vesseltest provides fake container runtime endpoints, for testing vessel consumers
+// without a docker daemon
+package vesseltest
+
+import (
+	"encoding/json"
+	"github.com/deepfence/vessel"
+	"github.com/deepfence/vessel/constants"
+	"github.com/docker/docker/api/types"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// APIVersion is the docker api version the fake daemon reports
+const APIVersion = "1.41"
+
+// versionPrefix matches the api version the docker client prefixes request paths with, like /v1.41
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+\.[0-9]+`)
+
+// DockerServer is a fake docker daemon listening on a unix socket. It serves the endpoints vessel uses for
+// detection: /_ping, /version, /info and /containers/json, answering with the canned responses of its fields.
+type DockerServer struct {
+	// Socket is the path of the unix socket the server listens on
+	Socket string
+	// URL is the unix:// endpoint of the socket
+	URL string
+
+	mu         sync.Mutex
+	containers []types.Container
+	info       types.Info
+	version    types.Version
+
+	dir      string
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewDockerServer starts a fake docker daemon on a unix socket in a new temporary directory, listing
+// the given containers. Close stops it and removes the socket.
+func NewDockerServer(containers ...types.Container) (*DockerServer, error) {
+	dir, err := ioutil.TempDir("", "vesseltest-")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s := &DockerServer{
+		Socket:     socket,
+		URL:        constants.UnixProtocol + "://" + socket,
+		containers: containers,
+		info: types.Info{
+			ID:            "vesseltest",
+			Driver:        "overlay2",
+			DockerRootDir: "/var/lib/docker",
+			Containers:    len(containers),
+		},
+		version: types.Version{
+			Version:    "20.10.6",
+			APIVersion: APIVersion,
+		},
+		dir:      dir,
+		listener: listener,
+	}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Endpoint returns the endpoint of the server, for vessel.Options.Endpoints
+func (s *DockerServer) Endpoint() vessel.Endpoint {
+	return vessel.Endpoint{URL: s.URL, Runtime: constants.DOCKER}
+}
+
+// Options returns detection options probing only the server, so detection doesn't depend on the host
+func (s *DockerServer) Options() vessel.Options {
+	return vessel.Options{Endpoints: []vessel.Endpoint{s.Endpoint()}}
+}
+
+// SetContainers replaces the containers the server lists
+func (s *DockerServer) SetContainers(containers ...types.Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers = containers
+	s.info.Containers = len(containers)
+}
+
+// SetInfo replaces the /info response of the server
+func (s *DockerServer) SetInfo(info types.Info) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+}
+
+// SetVersion replaces the /version response of the server
+func (s *DockerServer) SetVersion(version types.Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// Close stops the server and removes its socket
+func (s *DockerServer) Close() error {
+	err := s.server.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+func (s *DockerServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Api-Version", APIVersion)
+	w.Header().Set("Server", "Docker/"+s.version.Version+" (linux)")
+	switch versionPrefix.ReplaceAllString(r.URL.Path, "") {
+	case "/_ping":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Method != http.MethodHead {
+			w.Write([]byte("OK"))
+		}
+	case "/version":
+		writeJSON(w, s.version)
+	case "/info":
+		writeJSON(w, s.info)
+	case "/containers/json":
+		containers := s.containers
+		if containers == nil {
+			containers = []types.Container{}
+		}
+		writeJSON(w, containers)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"message": "vesseltest: " + r.URL.Path + " is not implemented"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,35 @@
+package vessel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// vsockAddr is the cid:port address of a vsock endpoint
+type vsockAddr string
+
+func (a vsockAddr) Network() string {
+	return "vsock"
+}
+
+func (a vsockAddr) String() string {
+	return string(a)
+}
+
+// parseVsockAddr parses a vsock address in the cid:port form
+func parseVsockAddr(addr string) (uint32, uint32, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vsock address %q, expected cid:port: %v", addr, err)
+	}
+	cid, err := strconv.ParseUint(host, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vsock cid %q", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vsock port %q", portStr)
+	}
+	return uint32(cid), uint32(port), nil
+}
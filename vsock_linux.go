@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package vessel
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"time"
+)
+
+// vsockConn is a connected AF_VSOCK stream socket, net.FileConn doesn't support the vsock family
+type vsockConn struct {
+	*os.File
+	remote vsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr {
+	return vsockAddr("")
+}
+
+func (c *vsockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// dialVsock connects to a vsock address in the cid:port form, the connect is abandoned once ctx is done
+func dialVsock(ctx context.Context, addr string) (net.Conn, error) {
+	cid, port, err := parseVsockAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// a non blocking fd makes the file use the runtime poller, so the connect can be waited for and
+	// deadlines work
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	file := os.NewFile(uintptr(fd), "vsock:"+addr)
+	err = unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port})
+	if err == unix.EINPROGRESS {
+		err = waitConnect(ctx, file)
+	}
+	if err != nil {
+		file.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, os.NewSyscallError("connect", err)
+	}
+	return &vsockConn{
+		File:   file,
+		remote: vsockAddr(addr),
+	}, nil
+}
+
+// waitConnect waits for the non blocking connect of the socket to complete, or for ctx to be done
+func waitConnect(ctx context.Context, file *os.File) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		file.SetWriteDeadline(deadline)
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// wakes up the wait below
+			file.SetWriteDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		file.SetWriteDeadline(time.Time{})
+	}()
+
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var connectErr error
+	waited := false
+	err = raw.Write(func(fd uintptr) bool {
+		// the socket becomes writable once the connect completes, successfully or not
+		if !waited {
+			waited = true
+			return false
+		}
+		soErr, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			connectErr = err
+			return true
+		}
+		switch unix.Errno(soErr) {
+		case unix.EINPROGRESS, unix.EALREADY, unix.EINTR:
+			return false
+		case 0, unix.EISCONN:
+			// a spurious wakeup leaves the socket unconnected without an error
+			if _, err := unix.Getpeername(int(fd)); err == unix.ENOTCONN {
+				return false
+			}
+			return true
+		default:
+			connectErr = unix.Errno(soErr)
+			return true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return connectErr
+}
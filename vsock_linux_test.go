@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package vessel
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/sys/unix"
+	"testing"
+	"time"
+)
+
+func TestDialVsockHonorsContext(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Skipf("vsock sockets aren't supported: %v", err)
+	}
+	unix.Close(fd)
+
+	// connecting to the local cid without a listener waits for the kernel's connect timeout, 2s by default
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	conn, err := dialVsock(ctx, "1:1234")
+	if err == nil {
+		conn.Close()
+		t.Skip("something listens on vsock 1:1234")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dialVsock() returned after %s, want it to stop at the context deadline", elapsed)
+	}
+	if ctx.Err() != nil && !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dialVsock() error = %v, want the context's error", err)
+	}
+}
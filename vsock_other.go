@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// dialVsock is not supported on this platform
+func dialVsock(ctx context.Context, addr string) (net.Conn, error) {
+	return nil, fmt.Errorf("vsock endpoints are not supported on this platform")
+}